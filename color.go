@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// palette holds the SGR parameters used to color output, keyed like the
+// capabilities of GNU grep's GREP_COLORS variable. An empty parameter
+// leaves that part of the output uncolored.
+type palette struct {
+	ms string // matched text in a selected line
+	mc string // matched text in a context line
+	sl string // the rest of a selected line
+	cx string // the rest of a context line
+	fn string // file names
+	ln string // line and column numbers
+	bn string // byte offsets
+	se string // separators: ':', '-' and "--"
+	ne bool   // do not append the erase-to-end-of-line sequence
+}
+
+// defaultPalette matches GNU grep's GREP_COLORS default,
+// ms=01;31:mc=01;31:sl=:cx=:fn=35:ln=32:bn=32:se=36.
+var defaultPalette = palette{ms: "01;31", mc: "01;31", fn: "35", ln: "32", bn: "32", se: "36"}
+
+// parseGrepColors returns the default palette updated by a GREP_COLORS
+// value, a colon-separated list such as "ms=01;32:fn=34:ne". Unknown
+// capabilities and values that are not SGR parameters are ignored.
+func parseGrepColors(env string) palette {
+	p := defaultPalette
+	for _, field := range strings.Split(env, ":") {
+		name, value, hasValue := strings.Cut(field, "=")
+		if !hasValue {
+			if name == "ne" {
+				p.ne = true
+			}
+			continue
+		}
+		if strings.Trim(value, "0123456789;") != "" {
+			continue
+		}
+		switch name {
+		case "mt":
+			p.ms, p.mc = value, value
+		case "ms":
+			p.ms = value
+		case "mc":
+			p.mc = value
+		case "sl":
+			p.sl = value
+		case "cx":
+			p.cx = value
+		case "fn":
+			p.fn = value
+		case "ln":
+			p.ln = value
+		case "bn":
+			p.bn = value
+		case "se":
+			p.se = value
+		}
+	}
+	return p
+}
+
+// paint wraps text in the SGR sequence for code. Empty text or an empty
+// code is returned unchanged.
+func (p *palette) paint(code, text string) string {
+	if code == "" || text == "" {
+		return text
+	}
+	if p.ne {
+		return "\x1b[" + code + "m" + text + "\x1b[m"
+	}
+	return "\x1b[" + code + "m\x1b[K" + text + "\x1b[m\x1b[K"
+}
+
+// colorEnabled reports whether output should be colored for a --color
+// mode: always, never, or auto, which colors only a terminal.
+func colorEnabled(mode string) bool {
+	switch mode {
+	case "always":
+		return true
+	case "auto":
+		fi, err := os.Stdout.Stat()
+		return err == nil && fi.Mode()&os.ModeCharDevice != 0
+	}
+	return false
+}
+
+// parseColorMode checks the value of --color, exiting on an invalid one.
+func parseColorMode(v string) string {
+	switch v {
+	case "auto", "always", "never":
+		return v
+	}
+	fmt.Fprintf(os.Stderr, "error: invalid color mode %q (want auto, always or never)\n", v)
+	os.Exit(2)
+	return ""
+}
@@ -1,27 +1,46 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rafaelmgr12/mygrep/regex"
 )
 
-// Usage: mygrep [-r] -E <pattern> [path ...]
+// Usage: mygrep [-E | -F | -G] [-r] [-i] [-v] [-o] [-c | -l | -L | -q] [-m num]
+//               [-n] [-b] [--column] [-H | -h] [-A num] [-B num] [-C num]
+//               [--color[=when]] [--replace <template>]
+//               (<pattern> | -f <file>) [path ...]
 
 func main() {
 	args := parseArgs()
-	re, err := Compile(args.Pattern)
+	if args.MaxCount == 0 {
+		// -m 0 selects nothing, so there is no need to read any input.
+		os.Exit(1)
+	}
+	patterns, err := loadPatterns(args)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: invalid pattern: %v\n", err)
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(2)
+	}
+	re, err := compilePatterns(patterns, args)
+	if err != nil {
+		reportPatternError(err)
 		os.Exit(2)
 	}
 
+	s := newSearcher(re, args)
+
 	found := false
 	paths := args.Paths
 	if len(paths) == 0 {
 		if args.Recursive {
 			paths = []string{"."}
 		} else {
-			found = grepStdin(re)
+			found = s.grepStdin(args.WithFilename)
 			if found {
 				os.Exit(0)
 			}
@@ -29,20 +48,36 @@ func main() {
 		}
 	}
 
-	multiPrefix := len(paths) > 1 || args.Recursive
+	multiPrefix := (len(paths) > 1 || args.Recursive || args.WithFilename) && !args.NoFilename
 	for _, p := range paths {
 		if args.Recursive {
-			if grepRecursive(re, p, multiPrefix) {
+			if s.grepRecursive(p, multiPrefix) {
 				found = true
 			}
 		} else {
-			if grepFile(re, p, multiPrefix) {
+			if s.grepFile(p, multiPrefix) {
 				found = true
 			}
 		}
+		if s.stopAll(found) {
+			break
+		}
 	}
 	if found {
 		os.Exit(0)
 	}
 	os.Exit(1)
 }
+
+// reportPatternError prints a compile error to stderr. Syntax errors are
+// followed by the pattern with a caret under the offending fragment.
+func reportPatternError(err error) {
+	var serr *regex.SyntaxError
+	if !errors.As(err, &serr) {
+		fmt.Fprintf(os.Stderr, "error: invalid pattern: %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "error: invalid pattern: %s\n", serr.Msg)
+	col := utf8.RuneCountInString(serr.Pattern[:serr.Offset])
+	fmt.Fprintf(os.Stderr, "  %s\n  %s^\n", serr.Pattern, strings.Repeat(" ", col))
+}
@@ -0,0 +1,137 @@
+package regex
+
+// ahoCorasick finds occurrences of any of a set of literals in a single
+// pass over the text. The trie and its failure links are compiled into a
+// DFA over byte classes: bytes that occur in no literal share class 0 and
+// always lead back to the root, which keeps the table small. Folding
+// case is just as cheap: the two cases of an ASCII letter share a class.
+type ahoCorasick struct {
+	classes [256]uint8
+	nclass  int
+	trans   []int32 // trans[state*nclass+class] is the next state
+	out     []int   // length of the longest literal ending at a state, 0 if none
+	term    []int   // index in lits of the first literal spelled by a state, -1 if none
+	depth   []int   // length of the prefix a state spells
+	maxLen  int
+}
+
+// newAhoCorasick builds the automaton for lits. With fold, an ASCII
+// letter in a literal also matches its other case.
+func newAhoCorasick(lits []string, fold bool) *ahoCorasick {
+	ac := &ahoCorasick{nclass: 1}
+	for _, lit := range lits {
+		for i := 0; i < len(lit); i++ {
+			c := lit[i]
+			if ac.classes[c] != 0 {
+				continue
+			}
+			ac.classes[c] = uint8(ac.nclass)
+			if lower := c | 0x20; fold && lower >= 'a' && lower <= 'z' {
+				ac.classes[lower] = uint8(ac.nclass)
+				ac.classes[lower&^0x20] = uint8(ac.nclass)
+			}
+			ac.nclass++
+		}
+		ac.maxLen = max(ac.maxLen, len(lit))
+	}
+
+	// Build the trie, with -1 marking a missing edge.
+	ac.addState(0)
+	for k, lit := range lits {
+		s := 0
+		for i := 0; i < len(lit); i++ {
+			e := s*ac.nclass + int(ac.classes[lit[i]])
+			if ac.trans[e] < 0 {
+				// addState may move trans, so index it afresh.
+				next := ac.addState(i + 1)
+				ac.trans[e] = int32(next)
+			}
+			s = int(ac.trans[e])
+		}
+		ac.out[s] = max(ac.out[s], len(lit))
+		if ac.term[s] < 0 {
+			ac.term[s] = k
+		}
+	}
+
+	// Fill in the missing edges breadth first, following failure links,
+	// and let each state report the literals ending at its failure state.
+	fail := make([]int, len(ac.out))
+	queue := []int{}
+	for c := 0; c < ac.nclass; c++ {
+		if t := &ac.trans[c]; *t < 0 {
+			*t = 0
+		} else {
+			queue = append(queue, int(*t))
+		}
+	}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		ac.out[s] = max(ac.out[s], ac.out[fail[s]])
+		for c := 0; c < ac.nclass; c++ {
+			t := &ac.trans[s*ac.nclass+c]
+			next := ac.trans[fail[s]*ac.nclass+c]
+			if *t < 0 {
+				*t = next
+				continue
+			}
+			fail[*t] = int(next)
+			queue = append(queue, int(*t))
+		}
+	}
+	return ac
+}
+
+// addState appends a state for a prefix of the given length with no
+// edges and returns its number.
+func (ac *ahoCorasick) addState(depth int) int {
+	for c := 0; c < ac.nclass; c++ {
+		ac.trans = append(ac.trans, -1)
+	}
+	ac.out = append(ac.out, 0)
+	ac.term = append(ac.term, -1)
+	ac.depth = append(ac.depth, depth)
+	return len(ac.out) - 1
+}
+
+// index returns the offset of the earliest starting occurrence of any
+// literal in text, or -1 if there is none. Occurrences are found by where
+// they end, so the scan continues until no later one could start sooner.
+func (ac *ahoCorasick) index(text []byte) int {
+	best := -1
+	s := 0
+	for i, c := range text {
+		if best >= 0 && i+1-ac.maxLen >= best {
+			break
+		}
+		s = int(ac.trans[s*ac.nclass+int(ac.classes[c])])
+		if n := ac.out[s]; n > 0 {
+			if start := i + 1 - n; best < 0 || start < best {
+				best = start
+			}
+		}
+	}
+	return best
+}
+
+// matchAt returns the end offset of the literal that matches text at
+// offset start, or -1 if none does. If several match, longest picks the
+// longest one and otherwise the one listed first wins. It walks the trie
+// edges only: a transition that does not go one level deeper is a
+// failure link and means no longer literal starts at start.
+func (ac *ahoCorasick) matchAt(text []byte, start int, longest bool) int {
+	end, first := -1, -1
+	s := 0
+	for i := start; i < len(text); i++ {
+		next := int(ac.trans[s*ac.nclass+int(ac.classes[text[i]])])
+		if ac.depth[next] != ac.depth[s]+1 {
+			break
+		}
+		s = next
+		if k := ac.term[s]; k >= 0 && (longest || first < 0 || k < first) {
+			end, first = i+1, k
+		}
+	}
+	return end
+}
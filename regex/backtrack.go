@@ -0,0 +1,438 @@
+package regex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"unicode/utf8"
+)
+
+// backtracker runs a program built by compileBacktrack over text with a
+// depth-first search. Choice points and the old value of every slot it
+// changes are kept on an explicit stack rather than the Go call stack, so
+// long texts cost heap memory but never stack depth. The bodies of atomic
+// groups, possessive repeats and lookaround run as nested searches, which
+// nest only as deeply as the pattern does.
+//
+// A path that comes back to a choice point at the same offset, without
+// consuming text in between, fails. The Pike VM drops such a path too,
+// since it reaches an instruction already visited at that offset; this
+// rule is what keeps empty loop iterations finite in both engines and
+// makes them agree on which ones count.
+type backtracker struct {
+	re   *Regex
+	text []byte
+	// slots holds the capture slots, then the offset where each group was
+	// last opened, then a count for each loop.
+	slots   []int
+	open    int // index of the first open-group slot
+	loops   int // index of the first loop slot
+	stack   []btEntry
+	snaps   [][]int   // slot values saved around nested searches
+	path    []btVisit // choice points on the current path
+	longest bool
+	end     int   // longest mode: furthest end reached from the current start
+	best    []int // longest mode: the capture slots of that match
+	// visited holds, in longest mode, the choice points already explored
+	// from the current start. A choice point is its instruction, offset
+	// and the values of stateSlots, which are all that decide where a path
+	// from it can end: the loop slots, and the capture and open-group
+	// slots of each group a backreference reads.
+	visited    map[btState]bool
+	stateSlots []int
+	depth      int // nesting of run calls
+}
+
+// btState identifies a choice point for the visited set.
+type btState struct {
+	pc, pos int
+	slots   string // the values of stateSlots, encoded
+}
+
+// btVisit is a choice point the current path went through: its
+// instruction, its offset and the counts of the loops around it. A nested
+// search starts with an entry at offset -1, which ends the scan for
+// revisits.
+type btVisit struct {
+	pc, pos int
+	counts  string
+}
+
+// btEntry is an entry on the backtracker's stack.
+type btEntry struct {
+	kind btKind
+	pc   int // btChoice: where to resume; btRestore: the slot; btSnapshot: index in snaps
+	pos  int // btChoice: the offset to resume at; btRestore: the old value
+	path int // btChoice: the length of the path at the choice point
+}
+
+type btKind uint8
+
+const (
+	btChoice   btKind = iota // an alternative path not tried yet
+	btRestore                // undo a change to one slot
+	btSnapshot               // undo the changes a nested search made
+)
+
+func newBacktracker(re *Regex, text []byte, longest bool) *backtracker {
+	ngroup := re.ncap + 1
+	b := &backtracker{
+		re:      re,
+		text:    text,
+		slots:   make([]int, 3*ngroup+re.bt.nloop),
+		open:    2 * ngroup,
+		loops:   3 * ngroup,
+		longest: longest,
+	}
+	if longest {
+		b.visited = map[btState]bool{}
+		for _, g := range re.bt.refs {
+			b.stateSlots = append(b.stateSlots, 2*g, 2*g+1, b.open+g)
+		}
+		for s := b.loops; s < len(b.slots); s++ {
+			b.stateSlots = append(b.stateSlots, s)
+		}
+	}
+	for i := range b.slots {
+		b.slots[i] = -1
+	}
+	return b
+}
+
+// match tries every rune boundary from offset pos on in turn as a start
+// position and returns the span of the first match found. In longest mode
+// every path from a start position is explored and the one reaching the
+// furthest end wins. The captures of the match are left in b.slots.
+func (b *backtracker) match(pos int) (int, int, bool) {
+	for i := pos; ; {
+		if b.re.pre != nil {
+			if i = b.re.pre.next(b.text, i); i < 0 {
+				return 0, 0, false
+			}
+		}
+		b.end = -1
+		clear(b.visited)
+		if end := b.run(0, i, -1); end >= 0 {
+			return i, end, true
+		}
+		if b.end >= 0 {
+			copy(b.slots, b.best)
+			return i, b.end, true
+		}
+		_, w := runeAt(b.text, i)
+		if w == 0 {
+			return 0, 0, false
+		}
+		i += w
+	}
+}
+
+// run searches from instruction pc at offset pos and returns the offset
+// where the program matched, or -1 if every path fails. A nested search
+// that must end at a given offset, as a lookbehind body must, passes it as
+// want; otherwise want is -1. On success the entries the search pushed
+// are dropped, leaving the slots as its path set them. On failure they
+// have all been undone.
+//
+// In longest mode the outermost search explores every path, so it skips
+// a choice point it has already explored: every end reachable from there
+// has been seen, and an earlier path wins ties. That bounds the work to
+// the number of distinct choice points rather than paths. Nested searches
+// stop at their first success and are not pruned.
+func (b *backtracker) run(pc, pos, want int) int {
+	base, snapBase := len(b.stack), len(b.snaps)
+	text := b.text
+	prune := b.visited != nil && b.depth == 0
+	b.depth++
+	pathBase := len(b.path)
+	b.path = append(b.path, btVisit{pc: -1, pos: -1})
+	defer func() {
+		b.depth--
+		b.path = b.path[:pathBase]
+	}()
+	for {
+		in := &b.re.bt.insts[pc]
+		if in.op == instSplit || in.op == instRepLoop {
+			if prune && b.seen(pc, pos) || b.revisits(in, pc, pos) {
+				var ok bool
+				if pc, pos, ok = b.backtrack(base); !ok {
+					return -1
+				}
+				continue
+			}
+		}
+		ok := true
+		switch in.op {
+		case instChar:
+			if r, w := runeAt(text, pos); w > 0 && in.n.matchRune(r) {
+				pc, pos = pc+1, pos+w
+			} else {
+				ok = false
+			}
+		case instAssert:
+			if assertAt(in.cond, text, pos) {
+				pc++
+			} else {
+				ok = false
+			}
+		case instSplit:
+			b.stack = append(b.stack, btEntry{kind: btChoice, pc: in.y, pos: pos, path: len(b.path)})
+			pc = in.x
+		case instJmp:
+			pc = in.x
+		case instCapOpen:
+			b.set(b.open+in.slot, pos)
+			pc++
+		case instCapClose:
+			b.set(2*in.slot, b.slots[b.open+in.slot])
+			b.set(2*in.slot+1, pos)
+			pc++
+		case instBackref:
+			if end := b.backref(in.n, pos); end >= 0 {
+				pc, pos = pc+1, end
+			} else {
+				ok = false
+			}
+		case instRepStart:
+			b.set(b.loops+in.slot, 0)
+			pc++
+		case instRepLoop:
+			n, count := in.n, b.slots[b.loops+in.slot]
+			switch {
+			case n.max >= 0 && count >= n.max:
+				pc = in.y
+			case count < n.min:
+				pc++
+			case n.lazy:
+				b.stack = append(b.stack, btEntry{kind: btChoice, pc: pc + 1, pos: pos, path: len(b.path)})
+				pc = in.y
+			default:
+				b.stack = append(b.stack, btEntry{kind: btChoice, pc: in.y, pos: pos, path: len(b.path)})
+				pc++
+			}
+		case instRepEnd:
+			// Past the minimum of an unbounded loop the count stays put:
+			// every further iteration is the same NFA copy of the body.
+			if s := b.loops + in.slot; in.n.max >= 0 || b.slots[s] < in.n.min {
+				b.set(s, b.slots[s]+1)
+			}
+			pc = in.x
+		case instAtomic:
+			idx := b.save()
+			if end := b.run(pc+1, pos, -1); end >= 0 {
+				b.stack = append(b.stack, btEntry{kind: btSnapshot, pc: idx})
+				pc, pos = in.x, end
+			} else {
+				b.snaps = b.snaps[:idx]
+				ok = false
+			}
+		case instPossessive:
+			idx := b.save()
+			if end := b.possessive(in.n, pc+1, pos); end >= 0 {
+				b.stack = append(b.stack, btEntry{kind: btSnapshot, pc: idx})
+				pc, pos = in.x, end
+			} else {
+				b.restore(idx)
+				ok = false
+			}
+		case instLook:
+			idx := b.save()
+			if b.look(in.n, pc+1, pos) == in.n.neg {
+				b.restore(idx)
+				ok = false
+				break
+			}
+			if in.n.neg {
+				// Captures made by a negative assertion are never visible.
+				b.restore(idx)
+			} else {
+				b.stack = append(b.stack, btEntry{kind: btSnapshot, pc: idx})
+			}
+			pc = in.x
+		case instSubMatch:
+			if want >= 0 && pos != want {
+				ok = false
+				break
+			}
+			b.stack, b.snaps = b.stack[:base], b.snaps[:snapBase]
+			return pos
+		case instMatch:
+			if !b.longest {
+				b.stack, b.snaps = b.stack[:base], b.snaps[:snapBase]
+				return pos
+			}
+			// Keep exploring for a longer match.
+			if pos > b.end {
+				b.end = pos
+				b.best = append(b.best[:0], b.slots[:b.open]...)
+			}
+			ok = false
+		}
+		if !ok {
+			if pc, pos, ok = b.backtrack(base); !ok {
+				return -1
+			}
+		}
+	}
+}
+
+// backtrack pops the stack down to the latest choice point above base,
+// undoing slot changes on the way, and returns where to resume. It reports
+// false if no choice point is left.
+func (b *backtracker) backtrack(base int) (int, int, bool) {
+	for len(b.stack) > base {
+		e := b.stack[len(b.stack)-1]
+		b.stack = b.stack[:len(b.stack)-1]
+		switch e.kind {
+		case btChoice:
+			b.path = b.path[:e.path]
+			return e.pc, e.pos, true
+		case btRestore:
+			b.slots[e.pc] = e.pos
+		case btSnapshot:
+			b.restore(e.pc)
+		}
+	}
+	return 0, 0, false
+}
+
+// seen reports whether the choice point at pc and pos, with the current
+// slots, was already explored from this start, and marks it explored.
+func (b *backtracker) seen(pc, pos int) bool {
+	st := btState{pc: pc, pos: pos, slots: b.encode(b.stateSlots, 0)}
+	if b.visited[st] {
+		return true
+	}
+	b.visited[st] = true
+	return false
+}
+
+// revisits reports whether the current path already went through the
+// choice point in at pc and pos, in the same iteration of each counted
+// loop around it, since it last consumed text. Otherwise it records the
+// visit on the path.
+func (b *backtracker) revisits(in *inst, pc, pos int) bool {
+	v := btVisit{pc: pc, pos: pos, counts: b.encode(in.loops, b.loops)}
+	for i := len(b.path) - 1; i >= 0 && b.path[i].pos == pos; i-- {
+		if b.path[i] == v {
+			return true
+		}
+	}
+	b.path = append(b.path, v)
+	return false
+}
+
+// encode returns the values of the slots at base plus each of offsets as
+// a string, or "" if there are none.
+func (b *backtracker) encode(offsets []int, base int) string {
+	if len(offsets) == 0 {
+		return ""
+	}
+	buf := make([]byte, 0, 4*len(offsets))
+	for _, o := range offsets {
+		buf = binary.AppendVarint(buf, int64(b.slots[base+o]))
+	}
+	return string(buf)
+}
+
+// set changes a slot, recording its old value for backtracking.
+func (b *backtracker) set(slot, v int) {
+	if old := b.slots[slot]; old != v {
+		b.stack = append(b.stack, btEntry{kind: btRestore, pc: slot, pos: old})
+		b.slots[slot] = v
+	}
+}
+
+// save records the capture and open-group slots before a nested search
+// and returns the index of the copy in b.snaps. Loop slots are left out:
+// only loops inside the nested body change them, and those reset them
+// before use.
+func (b *backtracker) save() int {
+	b.snaps = append(b.snaps, append([]int(nil), b.slots[:b.loops]...))
+	return len(b.snaps) - 1
+}
+
+// restore resets the slots to the copy at index idx of b.snaps and drops
+// it and every later copy.
+func (b *backtracker) restore(idx int) {
+	copy(b.slots, b.snaps[idx])
+	b.snaps = b.snaps[:idx]
+}
+
+// backref returns the offset after the text of the group referenced by n
+// when it occurs again at pos, or -1 if it does not or the group has not
+// matched.
+func (b *backtracker) backref(n *node, pos int) int {
+	lo, hi := b.slots[2*n.cap], b.slots[2*n.cap+1]
+	if lo < 0 {
+		return -1
+	}
+	val := b.text[lo:hi]
+	if n.fold {
+		return matchFold(b.text, val, pos)
+	}
+	if bytes.HasPrefix(b.text[pos:], val) {
+		return pos + len(val)
+	}
+	return -1
+}
+
+// possessive matches as many iterations of repeat n, with its body at pc,
+// as possible, each one taking its first successful path, and returns the
+// end offset, or -1 if fewer than n.min iterations match. It never retries
+// with fewer iterations.
+func (b *backtracker) possessive(n *node, pc, pos int) int {
+	count := 0
+	for n.max < 0 || count < n.max {
+		end := b.run(pc, pos, -1)
+		if end < 0 {
+			break
+		}
+		if end == pos {
+			// Further empty iterations would match the same way.
+			count = max(count, n.min)
+			break
+		}
+		pos = end
+		count++
+	}
+	if count < n.min {
+		return -1
+	}
+	return pos
+}
+
+// look reports whether the body at pc of lookaround n matches at pos: for
+// a lookahead starting there, for a lookbehind ending there and starting
+// between n.min and n.max characters back.
+func (b *backtracker) look(n *node, pc, pos int) bool {
+	if n.op == opLookahead {
+		return b.run(pc, pos, -1) >= 0
+	}
+	start := pos
+	for w := 0; w <= n.max; w++ {
+		if w >= n.min && b.run(pc, start, pos) >= 0 {
+			return true
+		}
+		_, size := runeBefore(b.text, start)
+		if size == 0 {
+			break
+		}
+		start -= size
+	}
+	return false
+}
+
+// matchFold compares val against text at offset i ignoring case and
+// returns the offset after the compared text, or -1 if it differs.
+func matchFold(text, val []byte, i int) int {
+	for len(val) > 0 {
+		want, wv := utf8.DecodeRune(val)
+		got, wt := runeAt(text, i)
+		if wt == 0 || !equalFold(got, want) {
+			return -1
+		}
+		val = val[wv:]
+		i += wt
+	}
+	return i
+}
@@ -0,0 +1,208 @@
+package regex
+
+import (
+	"sort"
+	"unicode"
+)
+
+// charClass is a set of characters stored as sorted, non-overlapping
+// inclusive lo-hi pairs.
+type charClass struct {
+	ranges []rune
+	neg    bool
+}
+
+// matches reports whether r is a member of the class.
+func (c *charClass) matches(r rune) bool {
+	// Find the first pair whose hi bound is >= r.
+	i := sort.Search(len(c.ranges)/2, func(i int) bool {
+		return c.ranges[2*i+1] >= r
+	})
+	in := i < len(c.ranges)/2 && c.ranges[2*i] <= r
+	return in != c.neg
+}
+
+// addRange adds the characters lo through hi to the class.
+func (c *charClass) addRange(lo, hi rune) {
+	c.ranges = append(c.ranges, lo, hi)
+}
+
+// addClass adds every member of o to the class.
+func (c *charClass) addClass(o *charClass) {
+	if !o.neg {
+		c.ranges = append(c.ranges, o.ranges...)
+		return
+	}
+	next := rune(0)
+	for i := 0; i < len(o.ranges); i += 2 {
+		if o.ranges[i] > next {
+			c.addRange(next, o.ranges[i]-1)
+		}
+		next = o.ranges[i+1] + 1
+	}
+	if next <= maxRune {
+		c.addRange(next, maxRune)
+	}
+}
+
+// clean sorts the ranges and merges overlapping or adjacent pairs so that
+// matches can binary search them.
+func (c *charClass) clean() *charClass {
+	n := len(c.ranges) / 2
+	sort.Sort(rangeSorter(c.ranges))
+	out := c.ranges[:0]
+	for i := 0; i < n; i++ {
+		lo, hi := c.ranges[2*i], c.ranges[2*i+1]
+		if len(out) > 0 && lo <= out[len(out)-1]+1 {
+			if hi > out[len(out)-1] {
+				out[len(out)-1] = hi
+			}
+			continue
+		}
+		out = append(out, lo, hi)
+	}
+	c.ranges = out
+	return c
+}
+
+// maxFoldRune is the largest character that has other cases.
+const maxFoldRune = 0x1E943
+
+// folded returns a copy of the class that also contains every other case
+// of its members, keeping the negation flag.
+func (c *charClass) folded() *charClass {
+	out := &charClass{ranges: append([]rune(nil), c.ranges...), neg: c.neg}
+	for i := 0; i < len(c.ranges); i += 2 {
+		for r := c.ranges[i]; r <= c.ranges[i+1] && r <= maxFoldRune; r++ {
+			for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+				out.addRange(f, f)
+			}
+		}
+	}
+	return out.clean()
+}
+
+// foldClass returns the class of r and every other case of r.
+func foldClass(r rune) *charClass {
+	c := &charClass{}
+	c.addRange(r, r)
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		c.addRange(f, f)
+	}
+	return c.clean()
+}
+
+// hasFold reports whether r has any other case.
+func hasFold(r rune) bool {
+	return unicode.SimpleFold(r) != r
+}
+
+// equalFold reports whether a and b are equal under simple Unicode case
+// folding.
+func equalFold(a, b rune) bool {
+	if a == b {
+		return true
+	}
+	for f := unicode.SimpleFold(a); f != a; f = unicode.SimpleFold(f) {
+		if f == b {
+			return true
+		}
+	}
+	return false
+}
+
+// maxRune is the largest character a class can contain.
+const maxRune = '\U0010FFFF'
+
+// rangeSorter sorts lo-hi pairs by their lo bound.
+type rangeSorter []rune
+
+func (r rangeSorter) Len() int           { return len(r) / 2 }
+func (r rangeSorter) Less(i, j int) bool { return r[2*i] < r[2*j] }
+func (r rangeSorter) Swap(i, j int) {
+	r[2*i], r[2*j] = r[2*j], r[2*i]
+	r[2*i+1], r[2*j+1] = r[2*j+1], r[2*i+1]
+}
+
+// addTable adds every member of a Unicode range table to the class.
+func (c *charClass) addTable(t *unicode.RangeTable) {
+	for _, r := range t.R16 {
+		c.addStrided(rune(r.Lo), rune(r.Hi), rune(r.Stride))
+	}
+	for _, r := range t.R32 {
+		c.addStrided(rune(r.Lo), rune(r.Hi), rune(r.Stride))
+	}
+}
+
+func (c *charClass) addStrided(lo, hi, stride rune) {
+	if stride == 1 {
+		c.addRange(lo, hi)
+		return
+	}
+	for r := lo; r <= hi; r += stride {
+		c.addRange(r, r)
+	}
+}
+
+// unicodeClass returns a fresh class for a Unicode general category such
+// as L or Lu, a script such as Greek, or Any. It returns nil for unknown
+// names.
+func unicodeClass(name string) *charClass {
+	cc := &charClass{}
+	if name == "Any" {
+		cc.addRange(0, maxRune)
+		return cc
+	}
+	t, ok := unicode.Categories[name]
+	if !ok {
+		if t, ok = unicode.Scripts[name]; !ok {
+			return nil
+		}
+	}
+	cc.addTable(t)
+	return cc.clean()
+}
+
+// wordRanges holds the members of \w: Unicode letters, marks, digits and
+// connector punctuation such as '_'.
+var wordRanges = func() []rune {
+	cc := &charClass{}
+	for _, t := range []*unicode.RangeTable{unicode.L, unicode.M, unicode.Nd, unicode.Pc} {
+		cc.addTable(t)
+	}
+	return cc.clean().ranges
+}()
+
+// perlClasses holds the classes named by backslash escapes such as \d.
+var perlClasses = map[byte]*charClass{
+	'd': {ranges: []rune{'0', '9'}},
+	'D': {ranges: []rune{'0', '9'}, neg: true},
+	's': {ranges: []rune{'\t', '\r', ' ', ' '}},
+	'S': {ranges: []rune{'\t', '\r', ' ', ' '}, neg: true},
+	'w': {ranges: wordRanges},
+	'W': {ranges: wordRanges, neg: true},
+}
+
+// isWordChar reports whether r is matched by \w.
+func isWordChar(r rune) bool {
+	return perlClasses['w'].matches(r)
+}
+
+// posixClasses holds the classes available as [:name:] inside a bracket
+// expression.
+var posixClasses = map[string]*charClass{
+	"alnum":  {ranges: []rune{'0', '9', 'A', 'Z', 'a', 'z'}},
+	"alpha":  {ranges: []rune{'A', 'Z', 'a', 'z'}},
+	"ascii":  {ranges: []rune{0, 0x7f}},
+	"blank":  {ranges: []rune{'\t', '\t', ' ', ' '}},
+	"cntrl":  {ranges: []rune{0, 0x1f, 0x7f, 0x7f}},
+	"digit":  {ranges: []rune{'0', '9'}},
+	"graph":  {ranges: []rune{'!', '~'}},
+	"lower":  {ranges: []rune{'a', 'z'}},
+	"print":  {ranges: []rune{' ', '~'}},
+	"punct":  {ranges: []rune{'!', '/', ':', '@', '[', '`', '{', '~'}},
+	"space":  {ranges: []rune{'\t', '\r', ' ', ' '}},
+	"upper":  {ranges: []rune{'A', 'Z'}},
+	"word":   {ranges: []rune{'0', '9', 'A', 'Z', '_', '_', 'a', 'z'}},
+	"xdigit": {ranges: []rune{'0', '9', 'A', 'F', 'a', 'f'}},
+}
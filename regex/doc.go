@@ -0,0 +1,25 @@
+/*
+Package regex implements the regular expression engine behind mygrep.
+
+The syntax is close to Perl and PCRE: literals, '.', character classes
+with ranges, escapes and POSIX [:name:] classes, Unicode property classes
+(\p{L}, \p{Greek}), the quantifiers *, +, ?, {n}, {n,} and {n,m} with lazy
+and possessive variants, capturing, named, non-capturing and atomic
+groups, backreferences, lookahead and lookbehind, anchors, word boundaries
+and the inline flags (?i), (?m), (?s) and (?x). Input is treated as UTF-8.
+
+Compile parses a pattern once into a syntax tree. Patterns that only use
+features an NFA can express run on a Pike VM in time linear in the input;
+the rest fall back to a backtracking matcher. Both engines return the
+leftmost-first match, so results do not depend on which one was chosen.
+CompilePOSIX and Regex.Longest switch to the leftmost-longest match that
+POSIX egrep reports, so that a|ab finds "ab" rather than "a". CompileBRE
+accepts the POSIX basic syntax of classic grep, where \( \) \| \{ \} \+
+and \? are the operators, and parses it into the same syntax tree.
+CompileOptions combines these choices with case-insensitive matching.
+
+All offsets returned by the Find methods are byte offsets into the input.
+MatchReader and FindReaderIndex search an io.RuneReader; patterns that run
+on the Pike VM are matched as the text streams in without buffering it.
+*/
+package regex
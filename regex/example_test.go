@@ -0,0 +1,55 @@
+package regex_test
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/rafaelmgr12/mygrep/regex"
+)
+
+func ExampleCompile() {
+	re, err := regex.Compile(`(ab`)
+	fmt.Println(re == nil, err)
+	// Output: true unterminated group at offset 0: "(ab"
+}
+
+func ExampleRegex_Match() {
+	re := regex.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	ok, _ := re.Match([]byte("2024-06-01"))
+	fmt.Println(ok)
+	// Output: true
+}
+
+func ExampleRegex_FindSubmatch() {
+	re := regex.MustCompile(`(?P<key>\w+)=(?P<value>\w+)`)
+	m := re.FindSubmatch([]byte("level=debug"))
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			fmt.Printf("%s: %s\n", name, m[i])
+		}
+	}
+	// Output:
+	// key: level
+	// value: debug
+}
+
+func ExampleRegex_FindAllIndex() {
+	re := regex.MustCompile(`\bid=\d+`)
+	fmt.Println(re.FindAllIndex([]byte("id=1 uid=2 id=33"), -1))
+	// Output: [[0 4] [11 16]]
+}
+
+func ExampleRegex_ReplaceAll() {
+	re := regex.MustCompile(`(\w+)@(\w+)\.com`)
+	fmt.Printf("%s\n", re.ReplaceAll([]byte("alice@example.com"), []byte("${2}: $1")))
+	// Output: example: alice
+}
+
+func ExampleRegex_FindReaderIndex() {
+	re := regex.MustCompile(`"id":\s*\d+`)
+	r := bufio.NewReader(strings.NewReader(`{"name":"x","id": 42,"tags":[]}`))
+	loc, err := re.FindReaderIndex(r)
+	fmt.Println(loc, err)
+	// Output: [12 20] <nil>
+}
@@ -0,0 +1,122 @@
+package regex
+
+// FindIndex returns the start and end offsets of the leftmost match in b,
+// or nil if there is no match.
+func (re *Regex) FindIndex(b []byte) []int {
+	return re.execute(b, 0, 2, false)
+}
+
+// Find returns the text of the leftmost match in b, or nil if there is no
+// match.
+func (re *Regex) Find(b []byte) []byte {
+	loc := re.FindIndex(b)
+	if loc == nil {
+		return nil
+	}
+	return b[loc[0]:loc[1]:loc[1]]
+}
+
+// FindSubmatchIndex returns the offsets of the leftmost match in b and of
+// each capturing group within it: group n spans result[2n] to
+// result[2n+1], and both are -1 if the group did not take part in the
+// match. It returns nil if there is no match.
+func (re *Regex) FindSubmatchIndex(b []byte) []int {
+	return re.execute(b, 0, 2*(re.ncap+1), false)
+}
+
+// FindSubmatch returns the text of the leftmost match in b followed by the
+// text of each capturing group, with nil for groups that did not take part
+// in the match. It returns nil if there is no match.
+func (re *Regex) FindSubmatch(b []byte) [][]byte {
+	return submatches(b, re.FindSubmatchIndex(b))
+}
+
+// FindAllIndex returns the offsets of successive non-overlapping matches
+// in b, as FindIndex does for one. At most n matches are returned, or all
+// of them if n is negative. It returns nil if there is no match.
+func (re *Regex) FindAllIndex(b []byte, n int) [][]int {
+	var out [][]int
+	re.allMatches(b, n, 2, func(loc []int) {
+		out = append(out, loc)
+	})
+	return out
+}
+
+// FindAll returns the text of successive non-overlapping matches in b. At
+// most n matches are returned, or all of them if n is negative. It returns
+// nil if there is no match.
+func (re *Regex) FindAll(b []byte, n int) [][]byte {
+	var out [][]byte
+	re.allMatches(b, n, 2, func(loc []int) {
+		out = append(out, b[loc[0]:loc[1]:loc[1]])
+	})
+	return out
+}
+
+// FindAllSubmatchIndex returns the match and group offsets of successive
+// non-overlapping matches in b, as FindSubmatchIndex does for one. At most
+// n matches are returned, or all of them if n is negative.
+func (re *Regex) FindAllSubmatchIndex(b []byte, n int) [][]int {
+	var out [][]int
+	re.allMatches(b, n, 2*(re.ncap+1), func(loc []int) {
+		out = append(out, loc)
+	})
+	return out
+}
+
+// FindAllSubmatch returns the match and group text of successive
+// non-overlapping matches in b, as FindSubmatch does for one. At most n
+// matches are returned, or all of them if n is negative.
+func (re *Regex) FindAllSubmatch(b []byte, n int) [][][]byte {
+	var out [][][]byte
+	re.allMatches(b, n, 2*(re.ncap+1), func(loc []int) {
+		out = append(out, submatches(b, loc))
+	})
+	return out
+}
+
+// allMatches calls deliver with the first nslots capture slots of up to n
+// successive non-overlapping matches in b, or of all of them if n is
+// negative. After an empty match the search resumes one character later,
+// and an empty match right where the previous match ended is skipped.
+func (re *Regex) allMatches(b []byte, n, nslots int, deliver func([]int)) {
+	prevEnd := -1
+	for pos, count := 0, 0; (n < 0 || count < n) && pos <= len(b); {
+		loc := re.execute(b, pos, nslots, false)
+		if loc == nil {
+			return
+		}
+		accept := true
+		if loc[1] == loc[0] {
+			if loc[0] == prevEnd {
+				accept = false
+			}
+			_, w := runeAt(b, loc[1])
+			if w == 0 {
+				w = 1
+			}
+			pos = loc[1] + w
+		} else {
+			pos = loc[1]
+		}
+		prevEnd = loc[1]
+		if accept {
+			deliver(loc)
+			count++
+		}
+	}
+}
+
+// submatches slices b by the capture slots in loc.
+func submatches(b []byte, loc []int) [][]byte {
+	if loc == nil {
+		return nil
+	}
+	out := make([][]byte, len(loc)/2)
+	for i := range out {
+		if lo, hi := loc[2*i], loc[2*i+1]; lo >= 0 {
+			out[i] = b[lo:hi:hi]
+		}
+	}
+	return out
+}
@@ -0,0 +1,107 @@
+package regex
+
+import "io"
+
+// input is the text a pikeVM runs over. Offsets passed to its methods
+// never decrease during a search, so a stream can be read incrementally.
+type input interface {
+	// step returns the character at offset pos and its width in bytes,
+	// or a width of 0 at the end of the input.
+	step(pos int) (rune, int)
+	// context returns the characters on either side of offset pos, with
+	// endOfText for a side past an edge of the input.
+	context(pos int) (before, after rune)
+	// skip returns the first offset at or after pos where a match could
+	// start, or -1 if none can.
+	skip(pos int) int
+}
+
+// inputBytes is an input held entirely in memory, which lets it skip
+// ahead using the pattern's prefilter.
+type inputBytes struct {
+	text []byte
+	pre  *prefilter // nil to try every offset
+}
+
+func (in *inputBytes) step(pos int) (rune, int) {
+	return runeAt(in.text, pos)
+}
+
+func (in *inputBytes) context(pos int) (rune, rune) {
+	before, after := endOfText, endOfText
+	if r, w := runeBefore(in.text, pos); w > 0 {
+		before = r
+	}
+	if r, w := runeAt(in.text, pos); w > 0 {
+		after = r
+	}
+	return before, after
+}
+
+func (in *inputBytes) skip(pos int) int {
+	if in.pre == nil {
+		return pos
+	}
+	return in.pre.next(in.text, pos)
+}
+
+// inputReader is an input read from an io.RuneReader as the search
+// advances. It only remembers the current character and the one before
+// it, so memory use does not grow with the length of the stream. The
+// first read error other than io.EOF ends the input and is kept in err.
+type inputReader struct {
+	r       io.RuneReader
+	started bool
+	pos     int  // offset of cur
+	prev    rune // character before pos, or endOfText
+	cur     rune // character at pos, or endOfText
+	width   int  // width of cur, 0 at the end of the input
+	err     error
+}
+
+func newInputReader(r io.RuneReader) *inputReader {
+	return &inputReader{r: r, prev: endOfText}
+}
+
+// seek reads forward until the current character starts at offset pos.
+func (in *inputReader) seek(pos int) {
+	if !in.started {
+		in.started = true
+		in.read()
+	}
+	for in.pos < pos && in.width > 0 {
+		in.prev = in.cur
+		in.pos += in.width
+		in.read()
+	}
+}
+
+func (in *inputReader) read() {
+	r, w, err := in.r.ReadRune()
+	if err != nil || w == 0 {
+		if err != io.EOF {
+			in.err = err
+		}
+		in.cur, in.width = endOfText, 0
+		return
+	}
+	in.cur, in.width = r, w
+}
+
+func (in *inputReader) step(pos int) (rune, int) {
+	in.seek(pos)
+	if in.width == 0 {
+		return 0, 0
+	}
+	return in.cur, in.width
+}
+
+func (in *inputReader) context(pos int) (rune, rune) {
+	in.seek(pos)
+	return in.prev, in.cur
+}
+
+// skip returns pos: text that has not been read cannot be searched ahead.
+func (in *inputReader) skip(pos int) int {
+	return pos
+}
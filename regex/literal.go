@@ -0,0 +1,547 @@
+package regex
+
+import (
+	"bytes"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxLits bounds the size of every literal set built while analysing a
+// pattern. Larger sets are dropped, or truncated where that stays valid,
+// so a pattern such as [a-z]{8} does not expand into billions of strings.
+const maxLits = 256
+
+// maxListLits bounds how many strings literalList expands the classes of
+// a literal list into.
+const maxListLits = 1 << 14
+
+// maxClassLits is the largest class expanded into one literal per rune.
+const maxClassLits = 10
+
+// litInfo describes the literal text that the matches of a node must
+// contain. A nil set means nothing is known. Sets are supersets: they may
+// list strings the node never matches, but every match is covered, which
+// is all a filter needs.
+type litInfo struct {
+	exact  []string // every match is one of these strings
+	prefix []string // every match starts with one of these non-empty strings
+	suffix []string // every match ends with one of these non-empty strings
+	inner  string   // every match contains this string, "" if unknown
+}
+
+// analyze computes the literal information for n. Wide or negated
+// classes, '.' and backreferences are unknown text.
+func analyze(n *node) litInfo {
+	switch n.op {
+	case opEmpty, opBeginText, opEndText, opBeginLine, opEndLine,
+		opWordBoundary, opNoWordBoundary, opWordStart, opWordEnd, opLookahead, opLookbehind:
+		// Zero-width: the text matched is always empty.
+		return exactInfo([]string{""})
+	case opLiteral:
+		if n.r == utf8.RuneError {
+			return litInfo{}
+		}
+		return exactInfo([]string{string(n.r)})
+	case opClass:
+		return exactInfo(classLits(n.class))
+	case opCapture, opGroup, opAtomic:
+		return analyze(n.subs[0])
+	case opConcat:
+		return analyzeConcat(n.subs)
+	case opAlternate:
+		return analyzeAlternate(n.subs)
+	case opRepeat:
+		return analyzeRepeat(n)
+	}
+	return litInfo{}
+}
+
+// exactInfo returns the information for a node matching exactly one of
+// lits, or nothing known if lits is nil.
+func exactInfo(lits []string) litInfo {
+	if lits == nil {
+		return litInfo{}
+	}
+	info := litInfo{exact: lits, prefix: nonEmpty(lits), suffix: nonEmpty(lits)}
+	if len(lits) == 1 {
+		info.inner = lits[0]
+	}
+	return info
+}
+
+// classLits lists the runes of a small non-negated class as strings.
+func classLits(c *charClass) []string {
+	if c.neg {
+		return nil
+	}
+	var lits []string
+	for i := 0; i < len(c.ranges); i += 2 {
+		lo, hi := c.ranges[i], c.ranges[i+1]
+		if hi-lo+1 > maxClassLits || lo <= utf8.RuneError && utf8.RuneError <= hi {
+			return nil
+		}
+		for r := lo; r <= hi; r++ {
+			if lits = append(lits, string(r)); len(lits) > maxClassLits {
+				return nil
+			}
+		}
+	}
+	return lits
+}
+
+func analyzeConcat(subs []*node) litInfo {
+	infos := make([]litInfo, len(subs))
+	for i, sub := range subs {
+		infos[i] = analyze(sub)
+	}
+	var out litInfo
+
+	// exact is the cross product of the parts while they are all exact.
+	// prefix extends it with the first inexact part's prefixes, and
+	// suffix is built the same way from the right.
+	exact := []string{""}
+	i := 0
+	for ; i < len(infos) && exact != nil && infos[i].exact != nil; i++ {
+		exact = cross(exact, infos[i].exact)
+	}
+	switch {
+	case exact == nil:
+		// The product grew too large; the last part kept still bounds
+		// how matches start.
+		out.prefix = prefixBefore(infos[:i])
+	case i == len(infos):
+		return exactInfo(exact)
+	default:
+		out.prefix = nonEmpty(exact)
+		if p := cross(exact, infos[i].prefix); infos[i].prefix != nil && p != nil {
+			out.prefix = p
+		}
+	}
+	out.suffix = suffixOf(infos)
+
+	// inner is the longest of the parts' own inner strings and of the runs
+	// of adjacent parts that each match a single fixed string.
+	run := ""
+	for _, info := range infos {
+		if len(info.inner) > len(out.inner) {
+			out.inner = info.inner
+		}
+		if len(info.exact) == 1 {
+			run += info.exact[0]
+			if len(run) > len(out.inner) {
+				out.inner = run
+			}
+		} else {
+			run = ""
+		}
+	}
+	return out
+}
+
+// prefixBefore returns the longest cross product of the leading exact
+// parts that stays within maxLits.
+func prefixBefore(infos []litInfo) []string {
+	exact := []string{""}
+	for _, info := range infos {
+		next := cross(exact, info.exact)
+		if next == nil {
+			break
+		}
+		exact = next
+	}
+	return nonEmpty(exact)
+}
+
+// suffixOf builds the suffix set of a concatenation from its right end.
+func suffixOf(infos []litInfo) []string {
+	exact := []string{""}
+	for i := len(infos) - 1; i >= 0; i-- {
+		info := infos[i]
+		if info.exact == nil {
+			if s := cross(info.suffix, exact); info.suffix != nil && s != nil {
+				return s
+			}
+			break
+		}
+		next := cross(info.exact, exact)
+		if next == nil {
+			break
+		}
+		exact = next
+	}
+	return nonEmpty(exact)
+}
+
+func analyzeAlternate(subs []*node) litInfo {
+	exact, prefix, suffix := []string{}, []string{}, []string{}
+	prefixes := make([][]string, len(subs))
+	for i, sub := range subs {
+		info := analyze(sub)
+		exact = union(exact, info.exact)
+		prefix = union(prefix, info.prefix)
+		suffix = union(suffix, info.suffix)
+		prefixes[i] = info.prefix
+	}
+	if exact != nil {
+		return exactInfo(exact)
+	}
+	if prefix == nil {
+		// Too many prefixes, as case variants of long alternatives easily
+		// give: shorter ones still bound where matches start.
+		prefix = shortenedPrefixes(prefixes)
+	}
+	return litInfo{prefix: prefix, suffix: suffix}
+}
+
+// shortenedPrefixes returns the union of the prefix sets cut to the
+// longest length in runes that keeps it within maxLits, or nil if one of
+// the sets is unknown or even one-rune prefixes are too many.
+func shortenedPrefixes(sets [][]string) []string {
+	longest := 0
+	for _, set := range sets {
+		if set == nil {
+			return nil
+		}
+		for _, lit := range set {
+			longest = max(longest, utf8.RuneCountInString(lit))
+		}
+	}
+	// Shorter cuts never give more strings, so search for the longest
+	// cut that fits.
+	n := sort.Search(longest, func(n int) bool { return cutPrefixes(sets, longest-n) != nil })
+	if n == longest {
+		return nil
+	}
+	return cutPrefixes(sets, longest-n)
+}
+
+// cutPrefixes returns the sorted union of the sets with every string cut
+// to its first n runes, or nil if it would exceed maxLits.
+func cutPrefixes(sets [][]string, n int) []string {
+	seen := map[string]bool{}
+	for _, set := range sets {
+		for _, lit := range set {
+			cut, i := lit, 0
+			for j := range lit {
+				if i == n {
+					cut = lit[:j]
+					break
+				}
+				i++
+			}
+			if seen[cut] = true; len(seen) > maxLits {
+				return nil
+			}
+		}
+	}
+	out := make([]string, 0, len(seen))
+	for lit := range seen {
+		out = append(out, lit)
+	}
+	slices.Sort(out)
+	return out
+}
+
+func analyzeRepeat(n *node) litInfo {
+	sub := analyze(n.subs[0])
+	if n.min == 0 {
+		if n.max == 1 && sub.exact != nil {
+			return exactInfo(union([]string{""}, sub.exact))
+		}
+		// The body may be skipped entirely.
+		return litInfo{}
+	}
+	if n.min == n.max && sub.exact != nil {
+		exact := []string{""}
+		for i := 0; i < n.min && exact != nil; i++ {
+			exact = cross(exact, sub.exact)
+		}
+		if exact != nil {
+			return exactInfo(exact)
+		}
+	}
+	// At least one iteration: matches start and end like the body and
+	// contain whatever it must contain.
+	return litInfo{prefix: sub.prefix, suffix: sub.suffix, inner: sub.inner}
+}
+
+// cross returns every concatenation of a string from a with one from b,
+// or nil if either is nil or the result would exceed maxLits.
+func cross(a, b []string) []string {
+	if a == nil || b == nil || len(a)*len(b) > maxLits {
+		return nil
+	}
+	out := make([]string, 0, len(a)*len(b))
+	for _, x := range a {
+		for _, y := range b {
+			out = append(out, x+y)
+		}
+	}
+	return dedup(out)
+}
+
+// union returns the strings in a or b, or nil if either is nil or the
+// result would exceed maxLits.
+func union(a, b []string) []string {
+	if a == nil || b == nil {
+		return nil
+	}
+	out := dedup(append(append([]string{}, a...), b...))
+	if len(out) > maxLits {
+		return nil
+	}
+	return out
+}
+
+func dedup(lits []string) []string {
+	slices.Sort(lits)
+	return slices.Compact(lits)
+}
+
+// nonEmpty returns lits as a prefix or suffix set. A set containing the
+// empty string says nothing about where a match is, so it becomes nil.
+func nonEmpty(lits []string) []string {
+	if lits == nil || slices.Contains(lits, "") {
+		return nil
+	}
+	return lits
+}
+
+// shortestPrefixes drops every literal that starts with another one in
+// the sorted set lits: wherever it occurs, the shorter one does too.
+func shortestPrefixes(lits []string) []string {
+	var out []string
+	for _, lit := range lits {
+		if len(out) > 0 && strings.HasPrefix(lit, out[len(out)-1]) {
+			continue
+		}
+		out = append(out, lit)
+	}
+	return out
+}
+
+// prefilter skips text that cannot contain a match, using literals found
+// by analyze. When every match starts with one of a set of literals the
+// search jumps straight to their occurrences; otherwise a literal every
+// match must contain lets a search give up early when it is absent.
+type prefilter struct {
+	prefix   *litFinder // nil if match starts are unknown
+	required *litFinder // nil if no required literal is known
+}
+
+// newPrefilter returns a prefilter for root, or nil if the pattern has no
+// literals worth scanning for.
+func newPrefilter(root *node) *prefilter {
+	info := analyze(root)
+	if info.prefix != nil {
+		return &prefilter{prefix: newLitFinder(shortestPrefixes(info.prefix))}
+	}
+	// Prefer whichever required literal has the longer shortest string,
+	// since it occurs least often.
+	best, bestLen := []string(nil), 0
+	if info.inner != "" {
+		best, bestLen = []string{info.inner}, len(info.inner)
+	}
+	if info.suffix != nil {
+		if n := len(slices.MinFunc(info.suffix, func(a, b string) int { return len(a) - len(b) })); n > bestLen {
+			best = info.suffix
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return &prefilter{required: newLitFinder(best)}
+}
+
+// rejects reports whether text[pos:] lacks the literals every match
+// needs, so the search can be skipped.
+func (pf *prefilter) rejects(text []byte, pos int) bool {
+	f := pf.required
+	if f == nil {
+		f = pf.prefix
+	}
+	return f.index(text[pos:]) < 0
+}
+
+// next returns the first offset at or after pos where a match could
+// start, or -1 if none can.
+func (pf *prefilter) next(text []byte, pos int) int {
+	if pf.prefix == nil {
+		return pos
+	}
+	i := pf.prefix.index(text[pos:])
+	if i < 0 {
+		return -1
+	}
+	return pos + i
+}
+
+// litFinder finds the earliest occurrence of any of a set of literals.
+type litFinder struct {
+	single []byte // the only literal, if there is one
+	ac     *ahoCorasick
+}
+
+func newLitFinder(lits []string) *litFinder {
+	if len(lits) == 1 {
+		return &litFinder{single: []byte(lits[0])}
+	}
+	return &litFinder{ac: newAhoCorasick(lits, false)}
+}
+
+// index returns the offset of the earliest starting occurrence of a
+// literal in text, or -1 if there is none.
+func (f *litFinder) index(text []byte) int {
+	if f.ac == nil {
+		return bytes.Index(text, f.single)
+	}
+	return f.ac.index(text)
+}
+
+// literalList returns the strings root matches if it is nothing but a
+// literal or an alternation of literals, such as a|bc|def, in the order
+// they are written. Small classes in a literal, such as the cases -i
+// makes of each letter, are expanded into one string per combination; the
+// strings of one alternative never match at the same offset, so their
+// order does not matter. If fold is true the strings are in lower case
+// and match ASCII letters of either case: every ASCII letter of the
+// pattern matched both of its cases, and expanding them would multiply
+// the strings for nothing. It returns nil otherwise, including when one
+// of the strings is empty or the expansion gives more than maxListLits
+// strings.
+func literalList(root *node) (lits []string, fold bool) {
+	if lits, fold = expandList(root, true); lits == nil {
+		lits, fold = expandList(root, false)
+	}
+	return lits, fold
+}
+
+// expandList does the work of literalList, folding ASCII letter pairs if
+// fold is set. It fails when fold is set and an ASCII letter matches in
+// one case only. The returned fold reports whether any pair was folded.
+func expandList(root *node, fold bool) ([]string, bool) {
+	root = ungroup(root)
+	subs := []*node{root}
+	if root.op == opAlternate {
+		subs = root.subs
+	}
+	lits := make([]string, 0, len(subs))
+	folded := false
+	for _, sub := range subs {
+		sub = ungroup(sub)
+		parts := []*node{sub}
+		if sub.op == opConcat {
+			parts = sub.subs
+		}
+		alts := []string{""}
+		for _, part := range parts {
+			var runes []string
+			switch {
+			case part.op == opEmpty:
+				// A flag change such as a leading (?i).
+				continue
+			case part.op == opLiteral && part.r != utf8.RuneError:
+				runes = []string{string(part.r)}
+			case part.op == opClass:
+				if runes = classLits(part.class); runes == nil {
+					return nil, false
+				}
+			default:
+				return nil, false
+			}
+			if fold {
+				n, ok := len(runes), false
+				if runes, ok = foldASCII(runes); !ok {
+					return nil, false
+				}
+				folded = folded || len(runes) < n
+			}
+			if len(alts)*len(runes) > maxLits {
+				return nil, false
+			}
+			next := make([]string, 0, len(alts)*len(runes))
+			for _, a := range alts {
+				for _, r := range runes {
+					next = append(next, a+r)
+				}
+			}
+			alts = next
+		}
+		if alts[0] == "" {
+			return nil, false
+		}
+		lits = append(lits, alts...)
+		if len(lits) > maxListLits && len(lits) > len(subs) {
+			return nil, false
+		}
+	}
+	return lits, folded
+}
+
+// foldASCII replaces both cases of each ASCII letter in runes, a list of
+// one-character strings, with the lower case one. It reports false if an
+// ASCII letter is there without its other case.
+func foldASCII(runes []string) ([]string, bool) {
+	var out []string
+	for _, r := range runes {
+		c := r[0]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' {
+			lower := c | 0x20
+			if !slices.Contains(runes, string(lower)) || !slices.Contains(runes, string(lower&^0x20)) {
+				return nil, false
+			}
+			if c != lower {
+				continue
+			}
+		}
+		out = append(out, r)
+	}
+	return out, true
+}
+
+// ungroup returns the body of n if it is a non-capturing group, such as
+// the group a leading (?i) opens, and n otherwise.
+func ungroup(n *node) *node {
+	for n.op == opGroup {
+		n = n.subs[0]
+	}
+	return n
+}
+
+// litSet matches a pattern that is a plain list of literals without
+// running either engine: the earliest occurrence of any literal is the
+// leftmost match, and the literal there is picked as the engines would.
+// This keeps searching for thousands of fixed strings at one pass over
+// the text.
+type litSet struct {
+	single []byte // the only literal, if there is one and no case folding
+	ac     *ahoCorasick
+}
+
+// newLitSet returns a set for the strings literalList found. With fold
+// the automaton matches ASCII letters of either case.
+func newLitSet(lits []string, fold bool) *litSet {
+	if len(lits) == 1 && !fold {
+		return &litSet{single: []byte(lits[0])}
+	}
+	return &litSet{ac: newAhoCorasick(lits, fold)}
+}
+
+// find returns the span of the leftmost match at or after offset pos, or
+// nil if there is none.
+func (ls *litSet) find(text []byte, pos int, longest bool) []int {
+	if ls.ac == nil {
+		i := bytes.Index(text[pos:], ls.single)
+		if i < 0 {
+			return nil
+		}
+		return []int{pos + i, pos + i + len(ls.single)}
+	}
+	i := ls.ac.index(text[pos:])
+	if i < 0 {
+		return nil
+	}
+	return []int{pos + i, ls.ac.matchAt(text, pos+i, longest)}
+}
@@ -0,0 +1,978 @@
+package regex
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// nodeOp identifies the kind of a syntax tree node.
+type nodeOp uint8
+
+const (
+	opEmpty          nodeOp = iota // matches the empty string
+	opLiteral                      // a single character
+	opAnyChar                      // '.' in (?s) mode
+	opAnyCharNotNL                 // '.'
+	opClass                        // [...], \d, \w
+	opBeginText                    // '^'
+	opEndText                      // '$'
+	opBeginLine                    // '^' in (?m) mode
+	opEndLine                      // '$' in (?m) mode
+	opWordBoundary                 // \b
+	opNoWordBoundary               // \B
+	opWordStart                    // \<
+	opWordEnd                      // \>
+	opConcat                       // subs matched in sequence
+	opAlternate                    // first sub that matches wins
+	opRepeat                       // subs[0] repeated between min and max times
+	opCapture                      // capturing group (...)
+	opGroup                        // non-capturing group (?:...)
+	opAtomic                       // atomic group (?>...)
+	opLookahead                    // (?=...), or (?!...) if neg
+	opLookbehind                   // (?<=...), or (?<!...) if neg
+	opBackref                      // \1, \g{-1}, \k<name>
+)
+
+// node is a single element of a parsed regular expression.
+type node struct {
+	op    nodeOp
+	r     rune       // opLiteral
+	fold  bool       // opBackref: match case-insensitively
+	class *charClass // opClass
+	min   int        // opRepeat; opLookbehind: shortest width in characters
+	max   int        // opRepeat, -1 means unbounded; opLookbehind: longest width
+	lazy  bool       // opRepeat: prefer fewer iterations
+	poss  bool       // opRepeat: never give back iterations
+	neg   bool       // opLookahead, opLookbehind: assert no match
+	cap   int        // opCapture, opBackref: group number
+	subs  []*node
+}
+
+// matchRune reports whether r is matched by a single-character node:
+// opLiteral, opAnyChar, opAnyCharNotNL or opClass.
+func (n *node) matchRune(r rune) bool {
+	switch n.op {
+	case opLiteral:
+		return r == n.r
+	case opAnyChar:
+		return true
+	case opAnyCharNotNL:
+		return r != '\n'
+	case opClass:
+		return n.class.matches(r)
+	}
+	return false
+}
+
+// SyntaxError describes a malformed pattern. Offset is the byte offset in
+// Pattern where the offending Fragment starts.
+type SyntaxError struct {
+	Pattern  string
+	Offset   int
+	Fragment string
+	Msg      string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at offset %d: %q", e.Msg, e.Offset, e.Fragment)
+}
+
+// flags holds the inline options set with (?imsx).
+type flags uint8
+
+const (
+	flagFold      flags = 1 << iota // i: case-insensitive
+	flagMultiLine                   // m: ^ and $ match at line breaks
+	flagDotNL                       // s: '.' matches '\n'
+	flagExtended                    // x: ignore whitespace and # comments
+)
+
+// parser turns a pattern string into a syntax tree.
+type parser struct {
+	pat    string
+	pos    int
+	flags  flags
+	bre    bool     // POSIX basic syntax: \( \) \| \{ \} \+ \? are the operators
+	branch int      // offset where the current branch started
+	star   int      // basic syntax: offset where a * is a literal
+	names  []string // group names indexed by group number; "" if unnamed
+	refs   []groupRef
+}
+
+// groupRef is a backreference that can only be checked once every group
+// in the pattern has been numbered. Named references also get their group
+// number filled in then.
+type groupRef struct {
+	n          *node
+	name       string
+	start, end int
+}
+
+// parse compiles pat into a syntax tree and returns it together with the
+// names of its capturing groups, indexed by group number. Element 0 stands
+// for the whole match and is always "". opts selects the dialect and
+// whether the pattern starts out case-insensitive.
+func parse(pat string, opts Options) (*node, []string, error) {
+	p := &parser{pat: pat, bre: opts.BRE, names: []string{""}}
+	if opts.IgnoreCase {
+		p.flags |= flagFold
+	}
+	n, err := p.parseAlternate()
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.more() {
+		return nil, nil, p.errorAt(p.pos, p.pos+p.opLen(')'), "unmatched ')'")
+	}
+	if err := p.resolveRefs(); err != nil {
+		return nil, nil, err
+	}
+	return n, p.names, nil
+}
+
+// resolveRefs fills in the group numbers of named backreferences and
+// rejects references to groups that do not exist.
+func (p *parser) resolveRefs() error {
+	for _, ref := range p.refs {
+		if ref.name == "" {
+			if ref.n.cap < 1 || ref.n.cap >= len(p.names) {
+				return p.errorAt(ref.start, ref.end, "reference to nonexistent group")
+			}
+			continue
+		}
+		ref.n.cap = -1
+		for i, name := range p.names {
+			if name == ref.name {
+				ref.n.cap = i
+				break
+			}
+		}
+		if ref.n.cap < 0 {
+			return p.errorAt(ref.start, ref.end, "unknown group name")
+		}
+	}
+	return nil
+}
+
+// errorAt returns a SyntaxError for the fragment pat[start:end].
+func (p *parser) errorAt(start, end int, msg string) error {
+	if end > len(p.pat) {
+		end = len(p.pat)
+	}
+	return &SyntaxError{Pattern: p.pat, Offset: start, Fragment: p.pat[start:end], Msg: msg}
+}
+
+func (p *parser) more() bool {
+	return p.pos < len(p.pat)
+}
+
+func (p *parser) peek() byte {
+	return p.pat[p.pos]
+}
+
+// opLen returns the length of the operator written c in extended syntax,
+// one of | ( ) + ? { }, if it starts at the current position, or 0 if it
+// does not. Basic syntax spells these operators with a backslash and
+// treats the bare characters as literals.
+func (p *parser) opLen(c byte) int {
+	if !p.bre {
+		if p.more() && p.peek() == c {
+			return 1
+		}
+		return 0
+	}
+	if p.pos+1 < len(p.pat) && p.pat[p.pos] == '\\' && p.pat[p.pos+1] == c {
+		return 2
+	}
+	return 0
+}
+
+// parseAlternate parses branches separated by '|'.
+func (p *parser) parseAlternate() (*node, error) {
+	var subs []*node
+	for {
+		n, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, n)
+		size := p.opLen('|')
+		if size == 0 {
+			break
+		}
+		p.pos += size
+	}
+	if len(subs) == 1 {
+		return subs[0], nil
+	}
+	return &node{op: opAlternate, subs: subs}, nil
+}
+
+// parseConcat parses a sequence of quantified atoms up to '|', ')' or the
+// end of the pattern.
+func (p *parser) parseConcat() (*node, error) {
+	var subs []*node
+	p.branch = p.pos
+	p.star = p.pos
+	for {
+		p.skipExtended()
+		if !p.more() || p.opLen('|') > 0 || p.opLen(')') > 0 {
+			break
+		}
+		n, err := p.parseRepeat()
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, n)
+	}
+	switch len(subs) {
+	case 0:
+		return &node{op: opEmpty}, nil
+	case 1:
+		return subs[0], nil
+	}
+	return &node{op: opConcat, subs: subs}, nil
+}
+
+// maxRepeat is the largest count accepted in a {n,m} quantifier.
+const maxRepeat = 1000
+
+// parseRepeat parses an atom followed by an optional quantifier. A
+// trailing '?' makes the quantifier lazy and a trailing '+' possessive.
+func (p *parser) parseRepeat() (*node, error) {
+	atom, err := p.parseAtom()
+	if err != nil {
+		return nil, err
+	}
+	if p.bre && (atom.op == opBeginText || atom.op == opBeginLine) {
+		// A * right after a leading ^ is literal, as at the start of
+		// the branch.
+		p.star = p.pos
+		return atom, nil
+	}
+	p.skipExtended()
+	min, max, size, ok := p.quantifier()
+	if !ok {
+		return atom, nil
+	}
+	start := p.pos
+	p.pos += size
+	if min > maxRepeat || max > maxRepeat || (max >= 0 && max < min) {
+		return nil, p.errorAt(start, p.pos, "invalid repeat count")
+	}
+	n := &node{op: opRepeat, min: min, max: max, subs: []*node{atom}}
+	if p.bre {
+		// Basic syntax has no lazy or possessive quantifiers.
+	} else if p.more() && p.peek() == '?' {
+		n.lazy = true
+		p.pos++
+	} else if p.more() && p.peek() == '+' {
+		n.poss = true
+		p.pos++
+	}
+	if _, _, next, ok := p.quantifier(); ok {
+		return nil, p.errorAt(start, p.pos+next, "nested repetition operator")
+	}
+	return n, nil
+}
+
+// quantifier reports whether a quantifier starts at the current position,
+// returning its bounds and length without consuming it. A '{' that does
+// not start a well-formed {n}, {n,} or {n,m} is not a quantifier; in
+// basic syntax, which also has \{,m\} as GNU grep does, such a \{ is
+// left for parseEscape to reject.
+func (p *parser) quantifier() (min, max, size int, ok bool) {
+	if !p.more() {
+		return 0, 0, 0, false
+	}
+	var open int
+	switch {
+	case p.peek() == '*':
+		return 0, -1, 1, true
+	case p.opLen('+') > 0:
+		return 1, -1, p.opLen('+'), true
+	case p.opLen('?') > 0:
+		return 0, 1, p.opLen('?'), true
+	case p.opLen('{') > 0:
+		open = p.opLen('{')
+	default:
+		return 0, 0, 0, false
+	}
+	closing := "}"
+	if p.bre {
+		closing = "\\}"
+	}
+	i := p.pos + open
+	min, i, ok = p.parseCount(i)
+	if !ok && !(p.bre && i < len(p.pat) && p.pat[i] == ',') {
+		return 0, 0, 0, false
+	}
+	max = min
+	if i < len(p.pat) && p.pat[i] == ',' {
+		i++
+		max = -1
+		if !strings.HasPrefix(p.pat[i:], closing) {
+			if max, i, ok = p.parseCount(i); !ok {
+				return 0, 0, 0, false
+			}
+		}
+	}
+	if !strings.HasPrefix(p.pat[i:], closing) {
+		return 0, 0, 0, false
+	}
+	return min, max, i + len(closing) - p.pos, true
+}
+
+// parseCount parses a decimal repeat count starting at i. Counts above
+// maxRepeat are clamped to maxRepeat+1 so the caller can reject them.
+func (p *parser) parseCount(i int) (int, int, bool) {
+	start := i
+	n := 0
+	for i < len(p.pat) && p.pat[i] >= '0' && p.pat[i] <= '9' {
+		if n <= maxRepeat {
+			n = n*10 + int(p.pat[i]-'0')
+		}
+		i++
+	}
+	if i == start {
+		return 0, i, false
+	}
+	if n > maxRepeat {
+		n = maxRepeat + 1
+	}
+	return n, i, true
+}
+
+// parseAtom parses a single literal, class, escape, anchor or group. In
+// basic syntax ^ and $ are anchors only at the start and end of a branch
+// and a * that starts a branch is literal, as POSIX specifies.
+func (p *parser) parseAtom() (*node, error) {
+	if _, _, size, ok := p.quantifier(); ok {
+		if p.bre && p.peek() == '*' && p.pos == p.star {
+			p.pos++
+			return p.literal('*'), nil
+		}
+		return nil, p.errorAt(p.pos, p.pos+size, "missing argument to repetition operator")
+	}
+	switch c := p.peek(); {
+	case p.opLen('(') > 0:
+		return p.parseGroup()
+	case c == '[':
+		return p.parseClass()
+	case c == '\\':
+		return p.parseEscape()
+	case c == '.':
+		p.pos++
+		if p.flags&flagDotNL != 0 {
+			return &node{op: opAnyChar}, nil
+		}
+		return &node{op: opAnyCharNotNL}, nil
+	case c == '^' && (!p.bre || p.pos == p.branch):
+		p.pos++
+		if p.flags&flagMultiLine != 0 {
+			return &node{op: opBeginLine}, nil
+		}
+		return &node{op: opBeginText}, nil
+	case c == '$' && (!p.bre || p.atBranchEnd(p.pos+1)):
+		p.pos++
+		if p.flags&flagMultiLine != 0 {
+			return &node{op: opEndLine}, nil
+		}
+		return &node{op: opEndText}, nil
+	}
+	return p.literal(p.nextRune()), nil
+}
+
+// atBranchEnd reports whether offset i ends a branch of a basic pattern:
+// it is the end of the pattern or followed by \) or \|.
+func (p *parser) atBranchEnd(i int) bool {
+	rest := p.pat[i:]
+	return rest == "" || strings.HasPrefix(rest, "\\)") || strings.HasPrefix(rest, "\\|")
+}
+
+// literal returns a node matching r. In (?i) mode a character with other
+// cases becomes a class of all of them, so folding costs nothing at match
+// time.
+func (p *parser) literal(r rune) *node {
+	if p.flags&flagFold != 0 && hasFold(r) {
+		return &node{op: opClass, class: foldClass(r)}
+	}
+	return &node{op: opLiteral, r: r}
+}
+
+// classNode returns a node matching cc, extended with the other cases of
+// its members in (?i) mode.
+func (p *parser) classNode(cc *charClass) *node {
+	if p.flags&flagFold != 0 {
+		cc = cc.folded()
+	}
+	return &node{op: opClass, class: cc}
+}
+
+// skipExtended skips whitespace and # comments in (?x) mode.
+func (p *parser) skipExtended() {
+	if p.flags&flagExtended == 0 {
+		return
+	}
+	for p.more() {
+		switch p.peek() {
+		case ' ', '\t', '\n', '\r', '\f', '\v':
+			p.pos++
+		case '#':
+			end := strings.IndexByte(p.pat[p.pos:], '\n')
+			if end < 0 {
+				p.pos = len(p.pat)
+			} else {
+				p.pos += end + 1
+			}
+		default:
+			return
+		}
+	}
+}
+
+// nextRune consumes and returns the character at the current position.
+func (p *parser) nextRune() rune {
+	r, w := utf8.DecodeRuneInString(p.pat[p.pos:])
+	p.pos += w
+	return r
+}
+
+// parseGroup parses a parenthesized group: a numbered capture (...), a
+// named capture (?P<name>...) or (?<name>...), a non-capturing group
+// (?:...), an atomic group (?>...), a lookaround assertion (?=...),
+// (?!...), (?<=...) or (?<!...), or a (?P=name) backreference. Basic
+// syntax only has the numbered \(...\) form.
+func (p *parser) parseGroup() (*node, error) {
+	open := p.pos
+	p.pos += p.opLen('(')
+	n := &node{op: opCapture}
+	outer := p.flags
+	if !p.bre && strings.HasPrefix(p.pat[p.pos:], "?") {
+		rest := p.pat[p.pos+1:]
+		switch {
+		case strings.HasPrefix(rest, ":"):
+			n.op = opGroup
+			p.pos += 2
+		case strings.HasPrefix(rest, ">"):
+			n.op = opAtomic
+			p.pos += 2
+		case strings.HasPrefix(rest, "="), strings.HasPrefix(rest, "!"):
+			n.op = opLookahead
+			n.neg = rest[0] == '!'
+			p.pos += 2
+		case strings.HasPrefix(rest, "<="), strings.HasPrefix(rest, "<!"):
+			n.op = opLookbehind
+			n.neg = rest[1] == '!'
+			p.pos += 3
+		case strings.HasPrefix(rest, "P="):
+			p.pos += 3
+			name, err := p.parseGroupName(open, ')')
+			if err != nil {
+				return nil, err
+			}
+			return p.addRef(0, name, open), nil
+		case strings.HasPrefix(rest, "P<"), strings.HasPrefix(rest, "<"):
+			p.pos = strings.IndexByte(p.pat[open:], '<') + open + 1
+			name, err := p.parseGroupName(open, '>')
+			if err != nil {
+				return nil, err
+			}
+			for _, existing := range p.names {
+				if existing == name {
+					return nil, p.errorAt(open, p.pos, "duplicate group name")
+				}
+			}
+			n.cap = len(p.names)
+			p.names = append(p.names, name)
+		default:
+			scoped, err := p.parseFlags(open)
+			if err != nil {
+				return nil, err
+			}
+			if !scoped {
+				return &node{op: opEmpty}, nil
+			}
+			n.op = opGroup
+		}
+	} else {
+		n.cap = len(p.names)
+		p.names = append(p.names, "")
+	}
+	sub, err := p.parseAlternate()
+	if err != nil {
+		return nil, err
+	}
+	size := p.opLen(')')
+	if size == 0 {
+		return nil, p.errorAt(open, len(p.pat), "unterminated group")
+	}
+	p.pos += size
+	p.flags = outer
+	n.subs = []*node{sub}
+	if n.op == opLookbehind {
+		if n.min, n.max = width(sub); n.max < 0 {
+			return nil, p.errorAt(open, p.pos, "lookbehind requires a bounded-length pattern")
+		}
+	}
+	return n, nil
+}
+
+// width returns the least and greatest number of characters n can match,
+// with a max of -1 when there is no bound. Backreferences are unbounded
+// because their length depends on the input.
+func width(n *node) (min, max int) {
+	switch n.op {
+	case opLiteral, opAnyChar, opAnyCharNotNL, opClass:
+		return 1, 1
+	case opConcat:
+		for _, sub := range n.subs {
+			lo, hi := width(sub)
+			min += lo
+			if max >= 0 {
+				max = addWidth(max, hi)
+			}
+		}
+		return min, max
+	case opAlternate:
+		min = -1
+		for _, sub := range n.subs {
+			lo, hi := width(sub)
+			if min < 0 || lo < min {
+				min = lo
+			}
+			if max >= 0 && (hi < 0 || hi > max) {
+				max = hi
+			}
+		}
+		return min, max
+	case opRepeat:
+		lo, hi := width(n.subs[0])
+		min = lo * n.min
+		switch {
+		case hi == 0:
+			max = 0
+		case hi < 0 || n.max < 0:
+			max = -1
+		default:
+			max = hi * n.max
+		}
+		return min, max
+	case opCapture, opGroup, opAtomic:
+		return width(n.subs[0])
+	case opBackref:
+		return 0, -1
+	}
+	return 0, 0
+}
+
+// addWidth adds two widths where -1 means unbounded.
+func addWidth(a, b int) int {
+	if a < 0 || b < 0 {
+		return -1
+	}
+	return a + b
+}
+
+// parseFlags parses the (?flags) and (?flags:...) forms, where flags is
+// a set of i, m, s and x letters optionally followed by '-' and letters to
+// clear. The group that began at offset open is positioned after ')' or
+// ':'. It reports whether a ':' introduced a scoped group; the caller
+// restores the outer flags when that group, or the enclosing one for the
+// unscoped form, is closed.
+func (p *parser) parseFlags(open int) (bool, error) {
+	p.pos++
+	f := p.flags
+	clear := false
+	sawFlag := false
+	for p.more() {
+		c := p.peek()
+		p.pos++
+		var bit flags
+		switch c {
+		case 'i':
+			bit = flagFold
+		case 'm':
+			bit = flagMultiLine
+		case 's':
+			bit = flagDotNL
+		case 'x':
+			bit = flagExtended
+		case '-':
+			if clear {
+				return false, p.errorAt(open, p.pos, "invalid flag group")
+			}
+			clear = true
+			sawFlag = false
+			continue
+		case ')', ':':
+			if !sawFlag {
+				return false, p.errorAt(open, p.pos, "invalid flag group")
+			}
+			p.flags = f
+			return c == ':', nil
+		default:
+			return false, p.errorAt(open, p.pos, "unknown group syntax")
+		}
+		sawFlag = true
+		if clear {
+			f &^= bit
+		} else {
+			f |= bit
+		}
+	}
+	return false, p.errorAt(open, len(p.pat), "unterminated group")
+}
+
+// parseGroupName reads a group name terminated by delim, for a construct
+// that began at offset start, and consumes the delimiter.
+func (p *parser) parseGroupName(start int, delim byte) (string, error) {
+	end := strings.IndexByte(p.pat[p.pos:], delim)
+	if end < 0 {
+		return "", p.errorAt(start, len(p.pat), "unterminated group name")
+	}
+	name := p.pat[p.pos : p.pos+end]
+	p.pos += end + 1
+	if !isValidGroupName(name) {
+		return "", p.errorAt(start, p.pos, "invalid group name")
+	}
+	return name, nil
+}
+
+// isValidGroupName reports whether name is a letter or underscore followed
+// by letters, digits and underscores.
+func isValidGroupName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c != '_' && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseEscape parses a backslash sequence outside a character class.
+func (p *parser) parseEscape() (*node, error) {
+	if p.pos+1 < len(p.pat) {
+		switch c := p.pat[p.pos+1]; {
+		case c >= '1' && c <= '9':
+			return p.parseNumberedRef(), nil
+		case c == 'g':
+			return p.parseGRef()
+		case c == 'b':
+			p.pos += 2
+			return &node{op: opWordBoundary}, nil
+		case c == 'B':
+			p.pos += 2
+			return &node{op: opNoWordBoundary}, nil
+		case c == '<':
+			p.pos += 2
+			return &node{op: opWordStart}, nil
+		case c == '>':
+			p.pos += 2
+			return &node{op: opWordEnd}, nil
+		case c == '{' && p.bre:
+			// A well-formed \{n,m\} was taken as a quantifier.
+			end := strings.Index(p.pat[p.pos:], "\\}")
+			if end < 0 {
+				return nil, p.errorAt(p.pos, len(p.pat), "unmatched \\{")
+			}
+			return nil, p.errorAt(p.pos, p.pos+end+2, "invalid content of \\{\\}")
+		case c == 'k':
+			start := p.pos
+			p.pos += 2
+			if !p.more() || p.peek() != '<' {
+				return nil, p.errorAt(start, p.pos, "invalid named backreference")
+			}
+			p.pos++
+			name, err := p.parseGroupName(start, '>')
+			if err != nil {
+				return nil, err
+			}
+			return p.addRef(0, name, start), nil
+		}
+	}
+	r, cc, err := p.parseEscapeChar()
+	if err != nil {
+		return nil, err
+	}
+	if cc != nil {
+		return p.classNode(cc), nil
+	}
+	return p.literal(r), nil
+}
+
+// addRef returns a backreference node to group num, or to the group called
+// name if it is not empty, for a reference that started at offset start
+// and ends at the current position.
+func (p *parser) addRef(num int, name string, start int) *node {
+	n := &node{op: opBackref, cap: num, fold: p.flags&flagFold != 0}
+	p.refs = append(p.refs, groupRef{n: n, name: name, start: start, end: p.pos})
+	return n
+}
+
+// parseNumberedRef parses a \N backreference. Several digits are taken
+// as one group number only while that group has already been opened, so
+// \12 means group 12 once twelve groups precede it and otherwise group 1
+// followed by a literal '2'.
+func (p *parser) parseNumberedRef() *node {
+	start := p.pos
+	p.pos++
+	num := int(p.nextRune() - '0')
+	opened := len(p.names) - 1
+	for p.more() && p.peek() >= '0' && p.peek() <= '9' {
+		next := num*10 + int(p.peek()-'0')
+		if next > opened {
+			break
+		}
+		num = next
+		p.pos++
+	}
+	return p.addRef(num, "", start)
+}
+
+// parseGRef parses the \gN, \g{N}, \g{-N} and \g{name} backreference
+// forms. A negative number counts back from the last group opened before
+// the reference, so \g{-1} is the most recent one.
+func (p *parser) parseGRef() (*node, error) {
+	start := p.pos
+	p.pos += 2
+	var ref string
+	if p.more() && p.peek() == '{' {
+		end := strings.IndexByte(p.pat[p.pos:], '}')
+		if end < 0 {
+			return nil, p.errorAt(start, len(p.pat), "unterminated \\g{...} backreference")
+		}
+		ref = p.pat[p.pos+1 : p.pos+end]
+		p.pos += end + 1
+	} else {
+		i := p.pos
+		if i < len(p.pat) && p.pat[i] == '-' {
+			i++
+		}
+		for i < len(p.pat) && p.pat[i] >= '0' && p.pat[i] <= '9' {
+			i++
+		}
+		ref = p.pat[p.pos:i]
+		p.pos = i
+	}
+	if isValidGroupName(ref) {
+		return p.addRef(0, ref, start), nil
+	}
+	num, err := strconv.Atoi(ref)
+	if err != nil || num == 0 || strings.HasPrefix(ref, "+") {
+		return nil, p.errorAt(start, p.pos, "invalid \\g backreference")
+	}
+	if num < 0 {
+		num += len(p.names)
+		if num < 1 {
+			return nil, p.errorAt(start, p.pos, "reference to nonexistent group")
+		}
+	}
+	return p.addRef(num, "", start), nil
+}
+
+// parseEscapeChar parses a backslash sequence that stands for either a
+// single character or a class such as \d. It is shared by escapes inside
+// and outside bracket expressions; inside brackets \b is a backspace.
+func (p *parser) parseEscapeChar() (rune, *charClass, error) {
+	if p.pos+1 >= len(p.pat) {
+		return 0, nil, p.errorAt(p.pos, p.pos+1, "trailing backslash at end of pattern")
+	}
+	start := p.pos
+	p.pos++
+	r := p.nextRune()
+	if r >= utf8.RuneSelf {
+		return r, nil, nil
+	}
+	c := byte(r)
+	if cc, ok := perlClasses[c]; ok {
+		return 0, cc, nil
+	}
+	switch c {
+	case 'p', 'P':
+		cc, err := p.parseUnicodeClass(start)
+		return 0, cc, err
+	case 'a':
+		return '\a', nil, nil
+	case 'b':
+		return '\b', nil, nil
+	case 'e':
+		return 0x1b, nil, nil
+	case 'f':
+		return '\f', nil, nil
+	case 'n':
+		return '\n', nil, nil
+	case 'r':
+		return '\r', nil, nil
+	case 't':
+		return '\t', nil, nil
+	case 'v':
+		return '\v', nil, nil
+	case 'x':
+		if p.more() && p.peek() == '{' {
+			end := strings.IndexByte(p.pat[p.pos:], '}')
+			if end < 0 {
+				return 0, nil, p.errorAt(start, len(p.pat), "unterminated \\x{...} escape")
+			}
+			p.pos += end + 1
+			return p.parseHex(start, p.pat[p.pos-end:p.pos-1], 1, 6)
+		}
+		p.pos = min(p.pos+2, len(p.pat))
+		return p.parseHex(start, p.pat[start+2:p.pos], 2, 2)
+	case 'u':
+		p.pos = min(p.pos+4, len(p.pat))
+		return p.parseHex(start, p.pat[start+2:p.pos], 4, 4)
+	}
+	return r, nil, nil
+}
+
+// parseUnicodeClass parses the name of a \pN, \p{Name}, \PN or \P{Name}
+// escape that started at offset start. A '^' after the brace negates the
+// class just like \P does.
+func (p *parser) parseUnicodeClass(start int) (*charClass, error) {
+	neg := p.pat[start+1] == 'P'
+	if !p.more() {
+		return nil, p.errorAt(start, p.pos, "missing Unicode class name")
+	}
+	var name string
+	if p.peek() == '{' {
+		end := strings.IndexByte(p.pat[p.pos:], '}')
+		if end < 0 {
+			return nil, p.errorAt(start, len(p.pat), "unterminated Unicode class")
+		}
+		name = p.pat[p.pos+1 : p.pos+end]
+		p.pos += end + 1
+	} else {
+		name = string(p.nextRune())
+	}
+	if strings.HasPrefix(name, "^") {
+		neg = !neg
+		name = name[1:]
+	}
+	cc := unicodeClass(name)
+	if cc == nil {
+		return nil, p.errorAt(start, p.pos, "unknown Unicode class")
+	}
+	cc.neg = neg
+	return cc, nil
+}
+
+// parseHex decodes the hex digits of a \x or \u escape that started at
+// offset start, requiring between minLen and maxLen digits.
+func (p *parser) parseHex(start int, digits string, minLen, maxLen int) (rune, *charClass, error) {
+	if len(digits) < minLen || len(digits) > maxLen {
+		return 0, nil, p.errorAt(start, p.pos, "invalid hexadecimal escape")
+	}
+	var r rune
+	for i := 0; i < len(digits); i++ {
+		d := digits[i]
+		switch {
+		case d >= '0' && d <= '9':
+			d -= '0'
+		case d >= 'a' && d <= 'f':
+			d -= 'a' - 10
+		case d >= 'A' && d <= 'F':
+			d -= 'A' - 10
+		default:
+			return 0, nil, p.errorAt(start, p.pos, "invalid hexadecimal escape")
+		}
+		r = r<<4 | rune(d)
+	}
+	if r > maxRune {
+		return 0, nil, p.errorAt(start, p.pos, "invalid hexadecimal escape")
+	}
+	return r, nil, nil
+}
+
+// parseClass parses a bracket expression such as [a-z_], [^\d] or
+// [[:alpha:]]. A ']' directly after the opening bracket (or after '^') is a
+// literal member, and '-' is literal at either end of the expression.
+func (p *parser) parseClass() (*node, error) {
+	start := p.pos
+	p.pos++
+	cc := &charClass{}
+	if p.more() && p.peek() == '^' {
+		cc.neg = true
+		p.pos++
+	}
+	first := true
+	for {
+		if !p.more() {
+			return nil, p.errorAt(start, len(p.pat), "unterminated character class")
+		}
+		if p.peek() == ']' && !first {
+			p.pos++
+			break
+		}
+		first = false
+		itemStart := p.pos
+		if p.peek() == '[' && p.pos+1 < len(p.pat) && p.pat[p.pos+1] == ':' {
+			named, err := p.parsePosixClass()
+			if err != nil {
+				return nil, err
+			}
+			if named != nil {
+				cc.addClass(named)
+				continue
+			}
+		}
+		lo, set, err := p.parseClassChar()
+		if err != nil {
+			return nil, err
+		}
+		if set != nil {
+			cc.addClass(set)
+			continue
+		}
+		hi := lo
+		if p.pos+1 < len(p.pat) && p.peek() == '-' && p.pat[p.pos+1] != ']' {
+			p.pos++
+			if hi, set, err = p.parseClassChar(); err != nil {
+				return nil, err
+			}
+			if set != nil || hi < lo {
+				return nil, p.errorAt(itemStart, p.pos, "invalid character class range")
+			}
+		}
+		cc.addRange(lo, hi)
+	}
+	return p.classNode(cc.clean()), nil
+}
+
+// parseClassChar parses one member of a bracket expression: a plain
+// character or a backslash escape. In basic syntax a backslash is an
+// ordinary member, as POSIX specifies.
+func (p *parser) parseClassChar() (rune, *charClass, error) {
+	if p.peek() == '\\' && !p.bre {
+		return p.parseEscapeChar()
+	}
+	return p.nextRune(), nil, nil
+}
+
+// parsePosixClass parses a [:name:] or [:^name:] class inside a bracket
+// expression. It returns nil without consuming anything when the text is
+// not terminated by ":]", in which case the '[' is an ordinary member.
+func (p *parser) parsePosixClass() (*charClass, error) {
+	end := strings.Index(p.pat[p.pos+2:], ":]")
+	if end < 0 {
+		return nil, nil
+	}
+	start := p.pos
+	name := p.pat[start+2 : start+2+end]
+	neg := strings.HasPrefix(name, "^")
+	cc, ok := posixClasses[strings.TrimPrefix(name, "^")]
+	if !ok {
+		return nil, p.errorAt(start, start+end+4, "unknown POSIX class")
+	}
+	p.pos = start + end + 4
+	if neg {
+		return &charClass{ranges: cc.ranges, neg: true}, nil
+	}
+	return cc, nil
+}
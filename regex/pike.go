@@ -0,0 +1,135 @@
+package regex
+
+// pikeVM runs a prog over an input by advancing every live NFA thread one
+// character at a time, so the search takes O(len(text) * len(prog)) time
+// regardless of the pattern. Threads are kept in priority order, which
+// gives the same leftmost-first results as the backtracker. In longest
+// mode every thread is run to completion and the leftmost-longest match
+// wins instead; nslots must then be at least 2.
+type pikeVM struct {
+	prog    *prog
+	in      input
+	nslots  int
+	longest bool
+}
+
+// thread is one NFA state together with the capture slots recorded on the
+// path that reached it.
+type thread struct {
+	pc   int
+	caps []int
+}
+
+// threadList is an ordered list of threads waiting on a character or a
+// match, together with every pc visited while building it. A pc reached a
+// second time at the same offset has lower priority and is dropped.
+type threadList struct {
+	seen    []bool
+	visited []int
+	threads []thread
+}
+
+func newThreadList(n int) *threadList {
+	return &threadList{seen: make([]bool, n)}
+}
+
+func (l *threadList) clear() {
+	for _, pc := range l.visited {
+		l.seen[pc] = false
+	}
+	l.visited = l.visited[:0]
+	l.threads = l.threads[:0]
+}
+
+// exec searches the input from offset pos on for the leftmost-first, or in
+// longest mode the leftmost-longest, match.
+// It returns nil if there is none, or the nslots capture slots of the
+// match, or an empty non-nil slice when nslots is 0. If any is set it
+// stops at the first match it reaches, which is enough to report whether
+// the text matches.
+func (vm *pikeVM) exec(pos int, any bool) []int {
+	n := len(vm.prog.insts)
+	clist, nlist := newThreadList(n), newThreadList(n)
+	start := make([]int, vm.nslots)
+	for i := range start {
+		start[i] = -1
+	}
+	var matched []int
+	for i := pos; ; {
+		if matched == nil {
+			if len(clist.threads) == 0 {
+				// No thread is running, so jump to the next offset where
+				// a match could start.
+				if i = vm.in.skip(i); i < 0 {
+					break
+				}
+			}
+			vm.add(clist, 0, i, start)
+		}
+		if len(clist.threads) == 0 && matched != nil {
+			break
+		}
+		r, w := vm.in.step(i)
+		for _, t := range clist.threads {
+			in := &vm.prog.insts[t.pc]
+			if in.op == instMatch {
+				if any {
+					return t.caps
+				}
+				if !vm.longest {
+					matched = t.caps
+					// Lower-priority threads can no longer win.
+					break
+				}
+				if matched == nil || t.caps[0] < matched[0] ||
+					t.caps[0] == matched[0] && i > matched[1] {
+					matched = t.caps
+				}
+				continue
+			}
+			if in.op == instChar && w > 0 && in.n.matchRune(r) {
+				vm.add(nlist, t.pc+1, i+w, t.caps)
+			}
+		}
+		if w == 0 {
+			break
+		}
+		clist, nlist = nlist, clist
+		nlist.clear()
+		i += w
+	}
+	return matched
+}
+
+// add follows empty transitions from pc at offset i and appends the
+// threads that are waiting on a character or a match to l.
+func (vm *pikeVM) add(l *threadList, pc, i int, caps []int) {
+	if l.seen[pc] {
+		return
+	}
+	l.seen[pc] = true
+	l.visited = append(l.visited, pc)
+	in := &vm.prog.insts[pc]
+	switch in.op {
+	case instJmp:
+		vm.add(l, in.x, i, caps)
+	case instSplit:
+		vm.add(l, in.x, i, caps)
+		vm.add(l, in.y, i, caps)
+	case instAssert:
+		if before, after := vm.in.context(i); assertContext(in.cond, before, after) {
+			vm.add(l, pc+1, i, caps)
+		}
+	case instSave:
+		if in.slot >= len(caps) {
+			vm.add(l, pc+1, i, caps)
+			return
+		}
+		old := caps[in.slot]
+		caps[in.slot] = i
+		vm.add(l, pc+1, i, caps)
+		caps[in.slot] = old
+	default:
+		l.threads = append(l.threads, thread{pc: pc, caps: append([]int{}, caps...)})
+	}
+}
@@ -0,0 +1,287 @@
+package regex
+
+import "slices"
+
+// instOp identifies the kind of an NFA instruction.
+type instOp uint8
+
+const (
+	instChar   instOp = iota // consume one character matched by n
+	instAssert               // zero-width assertion cond, then pc+1
+	instSplit                // continue at x, or with lower priority at y
+	instJmp                  // continue at x
+	instSave                 // record the offset in capture slot n, then pc+1
+	instMatch                // the whole pattern has matched
+
+	// The remaining instructions only appear in backtracker programs.
+	instCapOpen    // note where group slot starts
+	instCapClose   // set group slot to run from its start to here
+	instBackref    // match the text of group n.cap again
+	instRepStart   // reset the count of loop slot to 0
+	instRepLoop    // repeat n: run another iteration at pc+1 or exit to y
+	instRepEnd     // count an iteration of loop slot and go back to x
+	instAtomic     // match the body at pc+1 once, then continue at x
+	instPossessive // match as many iterations of repeat n as possible, then continue at x
+	instLook       // assert lookaround n with the body at pc+1, then continue at x
+	instSubMatch   // the body of an atomic, possessive or lookaround instruction has matched
+)
+
+// inst is a single program instruction.
+type inst struct {
+	op   instOp
+	n    *node  // instChar, instBackref, instRepLoop, instRepEnd, instPossessive, instLook
+	cond nodeOp // instAssert
+	x, y int    // instSplit, instJmp, instRepLoop, instRepEnd, instAtomic, instPossessive, instLook
+	slot int    // instSave; group number or loop number for the backtracker
+	// loops lists, for instSplit and instRepLoop in backtracker programs,
+	// the counted loops the instruction is in, its own included. The
+	// Pike VM runs a copy of a loop body per counted iteration; these
+	// counts tell the copies apart.
+	loops []int
+}
+
+// prog is a pattern compiled for one of the engines. Capture slots follow
+// the usual layout: group n uses slots 2n and 2n+1.
+type prog struct {
+	insts []inst
+	nloop int   // backtracker loops that need a counter
+	refs  []int // groups read by an instBackref
+}
+
+// maxProgSize bounds the number of instructions a pattern may compile to.
+// Counted repetition is expanded into copies of its body, so nested counts
+// can produce very large programs; those patterns stay on the backtracker.
+const maxProgSize = 20000
+
+// needsBacktrack reports whether n uses a feature the NFA engine cannot
+// express: backreferences, lookaround, atomic groups or possessive
+// quantifiers.
+func needsBacktrack(n *node) bool {
+	switch n.op {
+	case opBackref, opAtomic, opLookahead, opLookbehind:
+		return true
+	case opRepeat:
+		if n.poss {
+			return true
+		}
+	}
+	for _, sub := range n.subs {
+		if needsBacktrack(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileProg compiles a syntax tree into an NFA. It returns nil if the
+// tree needs the backtracker or the program would be too large.
+func compileProg(root *node) *prog {
+	if needsBacktrack(root) {
+		return nil
+	}
+	c := &progCompiler{}
+	c.emit(inst{op: instSave, slot: 0})
+	c.compile(root)
+	c.emit(inst{op: instSave, slot: 1})
+	c.emit(inst{op: instMatch})
+	if c.tooBig {
+		return nil
+	}
+	return &prog{insts: c.insts}
+}
+
+// compileBacktrack compiles a syntax tree into a program for the
+// backtracker. Unlike the NFA it covers every feature, and counted
+// repetition runs a loop with a counter instead of copying its body, so
+// the program stays proportional to the pattern.
+func compileBacktrack(root *node) *prog {
+	c := &progCompiler{bt: true}
+	c.compile(root)
+	c.emit(inst{op: instMatch})
+	p := &prog{insts: c.insts, nloop: c.nloop}
+	for _, in := range p.insts {
+		if in.op == instBackref && !slices.Contains(p.refs, in.n.cap) {
+			p.refs = append(p.refs, in.n.cap)
+		}
+	}
+	return p
+}
+
+// progCompiler emits instructions for a syntax tree.
+type progCompiler struct {
+	insts  []inst
+	tooBig bool
+	bt     bool  // compiling for the backtracker
+	nloop  int   // loops with a counter so far
+	active []int // backtracker: the counted loops being compiled
+}
+
+func (c *progCompiler) emit(in inst) int {
+	if !c.bt && len(c.insts) >= maxProgSize {
+		c.tooBig = true
+		return len(c.insts) - 1
+	}
+	c.insts = append(c.insts, in)
+	return len(c.insts) - 1
+}
+
+// split emits a split whose preferred branch is the next instruction; the
+// other branch is patched in later.
+func (c *progCompiler) split() int {
+	return c.emit(inst{op: instSplit, x: len(c.insts) + 1, loops: slices.Clone(c.active)})
+}
+
+func (c *progCompiler) compile(n *node) {
+	if c.tooBig {
+		return
+	}
+	switch n.op {
+	case opEmpty:
+	case opLiteral, opAnyChar, opAnyCharNotNL, opClass:
+		c.emit(inst{op: instChar, n: n})
+	case opBeginText, opEndText, opBeginLine, opEndLine, opWordBoundary, opNoWordBoundary,
+		opWordStart, opWordEnd:
+		c.emit(inst{op: instAssert, cond: n.op})
+	case opConcat:
+		for _, sub := range n.subs {
+			c.compile(sub)
+		}
+	case opAlternate:
+		var jmps []int
+		for i, sub := range n.subs {
+			if i == len(n.subs)-1 {
+				c.compile(sub)
+				break
+			}
+			s := c.split()
+			c.compile(sub)
+			jmps = append(jmps, c.emit(inst{op: instJmp}))
+			c.patch(s, len(c.insts))
+		}
+		for _, j := range jmps {
+			c.insts[j].x = len(c.insts)
+		}
+	case opCapture:
+		if c.bt {
+			// A backreference inside the group still sees the text of its
+			// last complete match until the group closes again.
+			c.emit(inst{op: instCapOpen, slot: n.cap})
+			c.compile(n.subs[0])
+			c.emit(inst{op: instCapClose, slot: n.cap})
+			break
+		}
+		c.emit(inst{op: instSave, slot: 2 * n.cap})
+		c.compile(n.subs[0])
+		c.emit(inst{op: instSave, slot: 2*n.cap + 1})
+	case opGroup:
+		c.compile(n.subs[0])
+	case opRepeat:
+		if c.bt {
+			c.compileLoop(n)
+		} else {
+			c.compileRepeat(n)
+		}
+	case opBackref:
+		c.emit(inst{op: instBackref, n: n})
+	case opAtomic:
+		c.compileBody(inst{op: instAtomic}, n.subs[0])
+	case opLookahead, opLookbehind:
+		c.compileBody(inst{op: instLook, n: n}, n.subs[0])
+	}
+}
+
+// compileBody emits in followed by body, which ends in instSubMatch, and
+// points in.x past it.
+func (c *progCompiler) compileBody(in inst, body *node) {
+	pc := c.emit(in)
+	c.compile(body)
+	c.emit(inst{op: instSubMatch})
+	c.insts[pc].x = len(c.insts)
+}
+
+// compileLoop compiles a repeat for the backtracker. Optional bodies and
+// unbounded loops with a minimum of at most one need only splits, as in
+// the NFA. Other counts use a counter, which stops at the minimum for an
+// unbounded loop: past it every iteration runs the same NFA copy of the
+// body. Empty iterations are dropped the way the NFA drops them, by the
+// backtracker's check for a path that returns to a choice point without
+// consuming text.
+func (c *progCompiler) compileLoop(n *node) {
+	body := n.subs[0]
+	switch {
+	case n.poss:
+		c.compileBody(inst{op: instPossessive, n: n}, body)
+		return
+	case n.max == 0:
+		return
+	case n.max == 1:
+		if n.min == 1 {
+			c.compile(body)
+			return
+		}
+		s := c.split()
+		c.compile(body)
+		c.patchRepeat(n, s, len(c.insts))
+		return
+	case n.max < 0 && n.min <= 1:
+		if n.min == 1 {
+			c.compile(body)
+		}
+		loop := c.split()
+		c.compile(body)
+		c.emit(inst{op: instJmp, x: loop})
+		c.patchRepeat(n, loop, len(c.insts))
+		return
+	}
+	l := c.nloop
+	c.nloop++
+	c.emit(inst{op: instRepStart, slot: l})
+	c.active = append(c.active, l)
+	loop := c.emit(inst{op: instRepLoop, n: n, slot: l, loops: slices.Clone(c.active)})
+	c.compile(body)
+	c.active = c.active[:len(c.active)-1]
+	c.emit(inst{op: instRepEnd, n: n, slot: l, x: loop})
+	c.insts[loop].y = len(c.insts)
+}
+
+// compileRepeat expands a repeat into n.min mandatory copies of its body
+// followed by either a loop or n.max-n.min optional copies.
+func (c *progCompiler) compileRepeat(n *node) {
+	for i := 0; i < n.min; i++ {
+		c.compile(n.subs[0])
+	}
+	if n.max < 0 {
+		loop := c.split()
+		c.compile(n.subs[0])
+		c.emit(inst{op: instJmp, x: loop})
+		c.patchRepeat(n, loop, len(c.insts))
+		return
+	}
+	var splits []int
+	for i := n.min; i < n.max; i++ {
+		splits = append(splits, c.split())
+		c.compile(n.subs[0])
+	}
+	for _, s := range splits {
+		c.patchRepeat(n, s, len(c.insts))
+	}
+}
+
+// patch sets the lower-priority branch of split s.
+func (c *progCompiler) patch(s, target int) {
+	if !c.tooBig {
+		c.insts[s].y = target
+	}
+}
+
+// patchRepeat sets the exit branch of a repeat's split s, swapping the
+// branches of a lazy repeat so that exiting is preferred.
+func (c *progCompiler) patchRepeat(n *node, s, exit int) {
+	if c.tooBig {
+		return
+	}
+	c.insts[s].y = exit
+	if n.lazy {
+		c.insts[s].x, c.insts[s].y = c.insts[s].y, c.insts[s].x
+	}
+}
@@ -0,0 +1,327 @@
+package regex
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Regex is a compiled regular expression. A Regex is safe for concurrent
+// use by multiple goroutines.
+type Regex struct {
+	pattern string
+	root    *node
+	prog    *prog      // nil if the pattern needs the backtracker
+	bt      *prog      // the program for the backtracker
+	pre     *prefilter // nil if the pattern has no useful literals
+	lits    *litSet    // set if the pattern is only a list of literals
+	ncap    int
+	names   []string
+	longest bool // leftmost-longest rather than leftmost-first
+}
+
+// Compile parses a regular expression and returns a Regex object.
+//
+// Patterns are matched by a linear-time NFA simulation whenever possible.
+// Backreferences, lookaround, atomic groups and possessive quantifiers
+// need a backtracking matcher instead, which can take exponential time on
+// pathological patterns. Literal text the pattern requires is searched for
+// first, so mostly literal patterns skip quickly over text that cannot
+// match. A pattern that is only an alternation of literals, like the one
+// QuoteMeta and | build from a list of fixed strings, is matched with an
+// Aho-Corasick automaton instead and scales to thousands of strings.
+func Compile(pattern string) (*Regex, error) {
+	return CompileOptions(pattern, Options{})
+}
+
+// Options selects the syntax and matching semantics for CompileOptions.
+// The zero value gives the behavior of Compile.
+type Options struct {
+	// BRE parses POSIX basic regular expressions, the default dialect of
+	// grep: \( \) \| \{ \} \+ and \? are the operators and the bare
+	// characters are literals. ^ and $ anchor only at the ends of a
+	// branch and a leading * is literal. The Perl extensions that start
+	// with (? are not available.
+	BRE bool
+	// IgnoreCase matches letters case-insensitively, as if the pattern
+	// began with (?i).
+	IgnoreCase bool
+	// Longest selects leftmost-longest semantics, as CompilePOSIX does.
+	Longest bool
+}
+
+// CompileOptions is like Compile but parses and matches the pattern as
+// opts describes.
+func CompileOptions(pattern string, opts Options) (*Regex, error) {
+	root, names, err := parse(pattern, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newRegex(pattern, root, names, opts), nil
+}
+
+// CompileList is like CompileOptions but compiles a list of patterns into
+// one Regex that matches wherever any of them does, as grep does with a
+// pattern file. Each pattern is parsed on its own: inline flags do not
+// carry over to the next one, backreferences refer to groups of their own
+// pattern and a syntax error reports the pattern it is in. The groups are
+// numbered across the list in order. An empty list matches nothing.
+func CompileList(patterns []string, opts Options) (*Regex, error) {
+	if len(patterns) == 1 {
+		return CompileOptions(patterns[0], opts)
+	}
+	alt := &node{op: opAlternate}
+	names := []string{""}
+	for _, pat := range patterns {
+		root, pnames, err := parse(pat, opts)
+		if err != nil {
+			return nil, err
+		}
+		renumber(root, len(names)-1)
+		names = append(names, pnames[1:]...)
+		if root.op == opAlternate {
+			// Splicing keeps a list of literals visible to literalList.
+			alt.subs = append(alt.subs, root.subs...)
+		} else {
+			alt.subs = append(alt.subs, root)
+		}
+	}
+	root := alt
+	if len(alt.subs) == 0 {
+		root = &node{op: opClass, class: &charClass{}}
+	}
+	return newRegex(strings.Join(patterns, "\n"), root, names, opts), nil
+}
+
+// renumber adds offset to the group numbers of the captures and
+// backreferences in n.
+func renumber(n *node, offset int) {
+	if n.op == opCapture || n.op == opBackref {
+		n.cap += offset
+	}
+	for _, sub := range n.subs {
+		renumber(sub, offset)
+	}
+}
+
+// newRegex compiles a parsed pattern for both engines.
+func newRegex(pattern string, root *node, names []string, opts Options) *Regex {
+	re := &Regex{
+		pattern: pattern,
+		root:    root,
+		prog:    compileProg(root),
+		bt:      compileBacktrack(root),
+		pre:     newPrefilter(root),
+		ncap:    len(names) - 1,
+		names:   names,
+		longest: opts.Longest,
+	}
+	if lits, fold := literalList(root); lits != nil {
+		re.lits = newLitSet(lits, fold)
+	}
+	return re
+}
+
+// MustCompile is like Compile but panics if the pattern cannot be parsed.
+// It simplifies initializing package-level variables.
+func MustCompile(pattern string) *Regex {
+	re, err := Compile(pattern)
+	if err != nil {
+		panic("regex: Compile(" + strconv.Quote(pattern) + "): " + err.Error())
+	}
+	return re
+}
+
+// CompilePOSIX is like Compile but the resulting Regex uses POSIX
+// leftmost-longest semantics, as egrep and grep -E do: among the matches
+// that start at the leftmost possible offset it picks the longest one,
+// instead of the one whose alternatives and quantifiers are preferred.
+// The syntax accepted is unchanged. When several paths produce the
+// longest match, the submatches are those of the preferred path.
+func CompilePOSIX(pattern string) (*Regex, error) {
+	return CompileOptions(pattern, Options{Longest: true})
+}
+
+// CompileBRE parses a POSIX basic regular expression, as grep does without
+// -E, and returns a Regex with the leftmost-longest semantics of
+// CompilePOSIX. See Options.BRE for the syntax.
+func CompileBRE(pattern string) (*Regex, error) {
+	return CompileOptions(pattern, Options{BRE: true, Longest: true})
+}
+
+// MustCompilePOSIX is like CompilePOSIX but panics if the pattern cannot
+// be parsed.
+func MustCompilePOSIX(pattern string) *Regex {
+	re, err := CompilePOSIX(pattern)
+	if err != nil {
+		panic("regex: CompilePOSIX(" + strconv.Quote(pattern) + "): " + err.Error())
+	}
+	return re
+}
+
+// Longest makes future searches use leftmost-longest semantics, as if the
+// pattern had been compiled with CompilePOSIX. It must not be called while
+// other goroutines are using re.
+func (re *Regex) Longest() {
+	re.longest = true
+}
+
+// QuoteMeta returns a string that escapes all regular expression
+// metacharacters inside s, so the result matches s literally.
+func QuoteMeta(s string) string {
+	var b strings.Builder
+	b.Grow(2 * len(s))
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(`\.+*?()|[]{}^$`, s[i]) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// String returns the source text used to compile the regular expression.
+// For CompileList that is the patterns joined by newlines.
+func (re *Regex) String() string {
+	return re.pattern
+}
+
+// NumSubexp returns the number of capturing groups in the expression.
+func (re *Regex) NumSubexp() int {
+	return re.ncap
+}
+
+// SubexpNames returns the names of the capturing groups, indexed by group
+// number. Element 0 stands for the whole match and unnamed groups have an
+// empty name. The returned slice must not be modified.
+func (re *Regex) SubexpNames() []string {
+	return re.names
+}
+
+// Match checks if the text matches the regular expression. The error is
+// reserved for failures during matching and is currently always nil.
+func (re *Regex) Match(text []byte) (bool, error) {
+	return re.execute(text, 0, 0, true) != nil, nil
+}
+
+// execute searches text for the leftmost match that starts at or after
+// offset pos and returns its first nslots capture slots, as absolute
+// offsets into text, or nil if there is no match. Looking at the whole of
+// text rather than text[pos:] keeps ^, \b and lookbehind correct when a
+// search resumes after an earlier match. If any is set the slots may
+// belong to any match rather than the leftmost-first or leftmost-longest
+// one.
+func (re *Regex) execute(text []byte, pos, nslots int, any bool) []int {
+	if re.lits != nil {
+		if loc := re.lits.find(text, pos, re.longest); loc != nil {
+			return loc[:nslots]
+		}
+		return nil
+	}
+	if re.pre != nil && re.pre.rejects(text, pos) {
+		return nil
+	}
+	if re.prog != nil {
+		return re.runVM(&inputBytes{text: text, pre: re.pre}, pos, nslots, any)
+	}
+	b := newBacktracker(re, text, re.longest && !any)
+	start, end, ok := b.match(pos)
+	if !ok {
+		return nil
+	}
+	b.slots[0], b.slots[1] = start, end
+	return append([]int{}, b.slots[:nslots]...)
+}
+
+// runVM is execute for the linear-time engine over any input.
+func (re *Regex) runVM(in input, pos, nslots int, any bool) []int {
+	longest := re.longest && !any
+	n := nslots
+	if longest && n < 2 {
+		// Comparing matches needs their start and end.
+		n = 2
+	}
+	vm := &pikeVM{prog: re.prog, in: in, nslots: n, longest: longest}
+	if caps := vm.exec(pos, any); caps != nil {
+		return caps[:nslots]
+	}
+	return nil
+}
+
+// runeAt decodes the character starting at offset i of text and returns
+// it with its width in bytes, or a width of 0 at the end of the text.
+// Invalid UTF-8 decodes as utf8.RuneError one byte at a time.
+func runeAt(text []byte, i int) (rune, int) {
+	if i >= len(text) {
+		return 0, 0
+	}
+	if c := text[i]; c < utf8.RuneSelf {
+		return rune(c), 1
+	}
+	return utf8.DecodeRune(text[i:])
+}
+
+// runeBefore decodes the character ending at offset i of text, returning
+// a width of 0 at the start of the text.
+func runeBefore(text []byte, i int) (rune, int) {
+	if i <= 0 {
+		return 0, 0
+	}
+	if c := text[i-1]; c < utf8.RuneSelf {
+		return rune(c), 1
+	}
+	return utf8.DecodeLastRune(text[:i])
+}
+
+// endOfText stands in for the character before the start or after the end
+// of the text when evaluating assertions.
+const endOfText rune = -1
+
+// assertAt reports whether the zero-width assertion op holds at offset i
+// of text.
+func assertAt(op nodeOp, text []byte, i int) bool {
+	before, after := endOfText, endOfText
+	if r, w := runeBefore(text, i); w > 0 {
+		before = r
+	}
+	if r, w := runeAt(text, i); w > 0 {
+		after = r
+	}
+	return assertContext(op, before, after)
+}
+
+// assertContext reports whether the zero-width assertion op holds between
+// the characters before and after, either of which may be endOfText.
+func assertContext(op nodeOp, before, after rune) bool {
+	switch op {
+	case opBeginText:
+		return before == endOfText
+	case opEndText:
+		return after == endOfText
+	case opBeginLine:
+		return before == endOfText || before == '\n'
+	case opEndLine:
+		return after == endOfText || after == '\n'
+	case opWordBoundary:
+		return atWordBoundary(before, after)
+	case opNoWordBoundary:
+		return !atWordBoundary(before, after)
+	case opWordStart:
+		return !isWordAt(before) && isWordAt(after)
+	case opWordEnd:
+		return isWordAt(before) && !isWordAt(after)
+	}
+	return false
+}
+
+// atWordBoundary reports whether before and after are a word character
+// and a non-word character in either order, treating endOfText as
+// non-word.
+func atWordBoundary(before, after rune) bool {
+	return isWordAt(before) != isWordAt(after)
+}
+
+// isWordAt reports whether r, which may be endOfText, is a word character.
+func isWordAt(r rune) bool {
+	return r != endOfText && isWordChar(r)
+}
@@ -0,0 +1,1437 @@
+package regex
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestRegex_Match_Features(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		want    bool
+	}{
+		{"a", "a", true},
+		{"a", "b", false},
+		{"^hello", "hello world", true},
+		{"^hello", "a hello", false},
+		{"world$", "hello world", true},
+		{"^hello$", "hello", true},
+		{"^hello$", "hello world", false},
+		{"h.llo", "hallo", true},
+		{"h.llo", "hllo", false},
+		{"[abc]+", "cab", true},
+		{"[^abc]+", "def", true},
+		{"a+b", "aaab", true},
+		{"a+b", "b", false},
+		{"ab?c", "abc", true},
+		{"ab?c", "ac", true},
+		{"ab?c", "abbc", false},
+		{"(ab)+c", "ababc", true},
+		{"(ab)+c", "aba", false},
+		{"(ab)\\1", "abab", true},
+		{"(ab)\\1", "abac", false},
+		{"a|b", "b", true},
+		{"a|b", "c", false},
+		{"\\d+", "12345", true},
+		{"\\w+", "abc_123", true},
+		{"", "anything", true},
+	}
+	for _, tt := range tests {
+		re, err := Compile(tt.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q) error: %v", tt.pattern, err)
+		}
+		got, err := re.Match([]byte(tt.text))
+		if err != nil {
+			t.Fatalf("Match(%q, %q) error: %v", tt.pattern, tt.text, err)
+		}
+		if got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRegex_Anchors_Exact(t *testing.T) {
+	re, err := Compile("^foo$")
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+	if ok, _ := re.Match([]byte("foo")); !ok {
+		t.Fatalf("expected exact match")
+	}
+	if ok, _ := re.Match([]byte("foobar")); ok {
+		t.Fatalf("unexpected match for prefix")
+	}
+}
+
+// Additional and more extensive tests for the regex engine
+func TestRegex_ComplexPatterns(t *testing.T) {
+	re, _ := Compile("^(foo|bar)+[abc]?$")
+	cases := []struct {
+		input string
+		want  bool
+	}{
+		{"foo", true},
+		{"bar", true},
+		{"foobar", true},
+		{"foofoo", true},
+		{"barbarc", true},
+		{"barbarx", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		got, _ := re.Match([]byte(c.input))
+		if got != c.want {
+			t.Errorf("input=%q: got %v, want %v", c.input, got, c.want)
+		}
+	}
+}
+
+func TestRegex_MultipleGroupsAndBackrefs(t *testing.T) {
+	re, _ := Compile("(a)(b)\\2\\1")
+	cases := []struct {
+		input string
+		want  bool
+	}{
+		{"abba", true},
+		{"abab", false},
+		{"ab", false},
+	}
+	for _, c := range cases {
+		got, _ := re.Match([]byte(c.input))
+		if got != c.want {
+			t.Errorf("input=%q: got %v, want %v", c.input, got, c.want)
+		}
+	}
+}
+
+func TestRegex_AlternationWithGroups(t *testing.T) {
+	re, _ := Compile("(foo|bar)baz")
+	cases := []struct {
+		input string
+		want  bool
+	}{
+		{"foobaz", true},
+		{"barbaz", true},
+		{"baz", false},
+		{"foobar", false},
+	}
+	for _, c := range cases {
+		got, _ := re.Match([]byte(c.input))
+		if got != c.want {
+			t.Errorf("input=%q: got %v, want %v", c.input, got, c.want)
+		}
+	}
+}
+
+func TestRegex_CharClassEdgeCases(t *testing.T) {
+	re, _ := Compile("[a]")
+	cases := []struct {
+		input string
+		want  bool
+	}{
+		{"a", true},
+		{"b", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		got, _ := re.Match([]byte(c.input))
+		if got != c.want {
+			t.Errorf("input=%q: got %v, want %v", c.input, got, c.want)
+		}
+	}
+
+	reNeg, _ := Compile("[^a]")
+	casesNeg := []struct {
+		input string
+		want  bool
+	}{
+		{"a", false},
+		{"b", true},
+		{"", false},
+	}
+	for _, c := range casesNeg {
+		got, _ := reNeg.Match([]byte(c.input))
+		if got != c.want {
+			t.Errorf("negated: input=%q: got %v, want %v", c.input, got, c.want)
+		}
+	}
+}
+
+func TestRegex_EmptyAndSpecialCases(t *testing.T) {
+	re, _ := Compile("")
+	cases := []struct {
+		input string
+		want  bool
+	}{
+		{"", true},
+		{"a", true},
+		{" ", true},
+	}
+	for _, c := range cases {
+		got, _ := re.Match([]byte(c.input))
+		if got != c.want {
+			t.Errorf("input=%q: got %v, want %v", c.input, got, c.want)
+		}
+	}
+
+	reDollar, _ := Compile("$")
+	casesDollar := []struct {
+		input string
+		want  bool
+	}{
+		{"", true},
+		{"a", true},
+	}
+	for _, c := range casesDollar {
+		got, _ := reDollar.Match([]byte(c.input))
+		if got != c.want {
+			t.Errorf("dollar: input=%q: got %v, want %v", c.input, got, c.want)
+		}
+	}
+}
+
+func TestRegexMatchFeatures(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		want    bool
+	}{
+		{"a", "a", true},
+		{"a", "b", false},
+		{"^hello", "hello world", true},
+		{"^hello", "a hello", false},
+		{"world$", "hello world", true},
+		{"^hello$", "hello", true},
+		{"^hello$", "hello world", false},
+		{"h.llo", "hallo", true},
+		{"h.llo", "hllo", false},
+		{"[abc]+", "cab", true},
+		{"[^abc]+", "def", true},
+		{"a+b", "aaab", true},
+		{"a+b", "b", false},
+		{"ab?c", "abc", true},
+		{"ab?c", "ac", true},
+		{"ab?c", "abbc", false},
+		{"(ab)+c", "ababc", true},
+		{"(ab)+c", "aba", false},
+		{"(ab)\\1", "abab", true},
+		{"(ab)\\1", "abac", false},
+		{"a|b", "b", true},
+		{"a|b", "c", false},
+		{"\\d+", "12345", true},
+		{"\\w+", "abc_123", true},
+		{"", "anything", true},
+	}
+
+	for _, tt := range tests {
+		re, err := Compile(tt.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q) error: %v", tt.pattern, err)
+		}
+		got, err := re.Match([]byte(tt.text))
+		if err != nil {
+			t.Fatalf("Match(%q, %q) error: %v", tt.pattern, tt.text, err)
+		}
+		if got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRegexAnchors(t *testing.T) {
+	re, err := Compile("^foo$")
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+	if ok, _ := re.Match([]byte("foo")); !ok {
+		t.Fatalf("expected exact match")
+	}
+	if ok, _ := re.Match([]byte("foobar")); ok {
+		t.Fatalf("unexpected match for prefix")
+	}
+}
+
+func TestCompile_RejectsMalformedPatterns(t *testing.T) {
+	for _, pat := range []string{"(ab", "[abc", "+a", "?a", "a\\", "a)", "[]", "a+??"} {
+		if _, err := Compile(pat); err == nil {
+			t.Errorf("Compile(%q): expected error", pat)
+		}
+	}
+}
+
+func TestRegex_AnchorsInsideAlternation(t *testing.T) {
+	re, err := Compile("^foo|bar$")
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+	cases := []struct {
+		input string
+		want  bool
+	}{
+		{"foo!", true},
+		{"!bar", true},
+		{"!foo", false},
+		{"bar!", false},
+	}
+	for _, c := range cases {
+		got, _ := re.Match([]byte(c.input))
+		if got != c.want {
+			t.Errorf("input=%q: got %v, want %v", c.input, got, c.want)
+		}
+	}
+}
+
+func TestCompile_SyntaxErrorPosition(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		offset   int
+		fragment string
+	}{
+		{"x(ab", 1, "(ab"},
+		{"a[bc", 1, "[bc"},
+		{"+a", 0, "+"},
+		{"ab\\", 2, "\\"},
+		{"a)b", 1, ")"},
+		{"a[]", 1, "[]"},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.pattern)
+		serr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Fatalf("Compile(%q) error = %v, want *SyntaxError", tt.pattern, err)
+		}
+		if serr.Offset != tt.offset || serr.Fragment != tt.fragment {
+			t.Errorf("Compile(%q): offset %d fragment %q, want %d %q", tt.pattern, serr.Offset, serr.Fragment, tt.offset, tt.fragment)
+		}
+	}
+}
+
+func TestRegex_StarAndCountedRepetition(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		want    bool
+	}{
+		{"ab*c", "ac", true},
+		{"ab*c", "abbbc", true},
+		{"ab*c", "ab*c", false},
+		{"^a*$", "", true},
+		{"^a{3}$", "aaa", true},
+		{"^a{3}$", "aa", false},
+		{"^a{3}$", "aaaa", false},
+		{"^a{2,}$", "a", false},
+		{"^a{2,}$", "aaaaa", true},
+		{"^a{2,3}$", "aaa", true},
+		{"^a{2,3}$", "aaaa", false},
+		{"^a{0}b$", "b", true},
+		{"^(ab){2}$", "abab", true},
+		{"^(ab){2}$", "ab", false},
+		{"^[0-9x]{2}-\\d{4}$", "xx-2024", true},
+		{"^(a|b)\\1{2}$", "bbb", true},
+		{"^(a|b)\\1{2}$", "baa", false},
+		{"^(a*)*$", "aaa", true},
+		{"^(a*)+b$", "aaac", false},
+		{"a{,2}", "a{,2}", true},
+		{"a{x}", "a{x}", true},
+	}
+	for _, tt := range tests {
+		re, err := Compile(tt.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q) error: %v", tt.pattern, err)
+		}
+		got, _ := re.Match([]byte(tt.text))
+		if got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCompile_InvalidRepeatCounts(t *testing.T) {
+	for _, pat := range []string{"a{3,2}", "a{1001}", "a{2}{3}", "a**", "*a", "{1}"} {
+		if _, err := Compile(pat); err == nil {
+			t.Errorf("Compile(%q): expected error", pat)
+		}
+	}
+}
+
+func TestRegex_LazyAndPossessiveQuantifiers(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		want    bool
+	}{
+		{"^a+?b$", "aaab", true},
+		{"^a??b$", "ab", true},
+		{"^a*?$", "aaa", true},
+		{"^\"(.*?)\"\\1$", "\"ab\"ab", true},
+		{"^a{2,3}?$", "aaa", true},
+		{"^a++a$", "aaa", false},
+		{"^a*+b$", "aaab", true},
+		{"^a?+a$", "a", false},
+		{"^(a?)++$", "", true},
+		{"^[ab]{1,2}+b$", "abb", true},
+		{"^[ab]{1,2}+b$", "ab", false},
+		{"^(a+)++b$", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaac", false},
+	}
+	for _, tt := range tests {
+		re, err := Compile(tt.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q) error: %v", tt.pattern, err)
+		}
+		got, _ := re.Match([]byte(tt.text))
+		if got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRegex_CharacterClasses(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		want    bool
+	}{
+		{"^[a-z]+$", "hello", true},
+		{"^[a-z]+$", "Hello", false},
+		{"^[a-z]$", "-", false},
+		{"^[A-Fa-f0-9]+$", "DeadBeef42", true},
+		{"^[a-]+$", "a-a", true},
+		{"^[-a]+$", "-a-", true},
+		{"^[\\d_]+$", "12_3", true},
+		{"^[\\d_]+$", "\\d", false},
+		{"^[\\w.]+$", "file.go", true},
+		{"^[\\s]+$", " \t", true},
+		{"^[\\]]$", "]", true},
+		{"^[]a]+$", "]a]", true},
+		{"^[^]a]$", "]", false},
+		{"^[^]a]$", "b", true},
+		{"^[\\\\]$", "\\", true},
+		{"^[[:alpha:]]+$", "abcXYZ", true},
+		{"^[[:alpha:]]+$", "abc1", false},
+		{"^[[:space:]]+$", " \t\r\n", true},
+		{"^[[:xdigit:]]+$", "0fA9", true},
+		{"^[[:xdigit:]]+$", "0g", false},
+		{"^[[:^digit:]]+$", "abc", true},
+		{"^[[:digit:][:upper:]_]+$", "A1_B2", true},
+		{"^[[a]+$", "[a[", true},
+	}
+	for _, tt := range tests {
+		re, err := Compile(tt.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q) error: %v", tt.pattern, err)
+		}
+		got, _ := re.Match([]byte(tt.text))
+		if got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCompile_InvalidCharacterClasses(t *testing.T) {
+	for _, pat := range []string{"[z-a]", "[a-\\d]", "[[:nope:]]", "[]", "[^]", "[a"} {
+		if _, err := Compile(pat); err == nil {
+			t.Errorf("Compile(%q): expected error", pat)
+		}
+	}
+}
+
+func TestRegex_Escapes(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		want    bool
+	}{
+		{"a\\sb", "a b", true},
+		{"a\\sb", "asb", false},
+		{"^\\S+$", "no-space", true},
+		{"^\\S+$", "a b", false},
+		{"^\\D+$", "abc", true},
+		{"^\\D+$", "a1c", false},
+		{"^\\W+$", "-+!", true},
+		{"^\\W+$", "a", false},
+		{"^[\\D]+$", "x-y", true},
+		{"\\bcat\\b", "the cat sat", true},
+		{"\\bcat\\b", "concatenate", false},
+		{"\\Bcat\\B", "concatenate", true},
+		{"^\\b$", "", false},
+		{"\\<cat\\>", "the cat sat", true},
+		{"\\<cat", "concat", false},
+		{"cat\\>", "cats", false},
+		{"\\>x\\<", "a-x-b", false},
+		{"\\<\\w+\\>", "çava", true},
+		{"\\<", "", false},
+		{"\\>", "-", false},
+		{"a\\tb", "a\tb", true},
+		{"^[^\\t]+\\t[^\\t]+$", "key\tvalue", true},
+		{"a\\nb", "a\nb", true},
+		{"\\x41\\x{42}", "AB", true},
+		{"\\u0043", "C", true},
+		{"[\\x30-\\x39]+", "42", true},
+		{"\\q", "q", true},
+	}
+	for _, tt := range tests {
+		re, err := Compile(tt.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q) error: %v", tt.pattern, err)
+		}
+		got, _ := re.Match([]byte(tt.text))
+		if got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCompile_InvalidEscapes(t *testing.T) {
+	for _, pat := range []string{"\\x4", "\\xZZ", "\\x{}", "\\x{110000}", "\\x{41", "\\u12", "\\u12G4"} {
+		if _, err := Compile(pat); err == nil {
+			t.Errorf("Compile(%q): expected error", pat)
+		}
+	}
+}
+
+func TestRegex_UTF8(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		want    bool
+	}{
+		{"h.llo", "héllo", true},
+		{"^h.llo$", "h€llo", true},
+		{"^..$", "é", false},
+		{"^.$", "日", true},
+		{"[é]", "é", true},
+		{"^[^é]$", "e", true},
+		{"^[^é]$", "ê", true},
+		{"^[^é]$", "é", false},
+		{"^[à-ÿ]+$", "éèê", true},
+		{"^\\w+$", "naïve_ñandú", true},
+		{"^\\w+$", "日本語", true},
+		{"^\\W$", "—", true},
+		{"\\bçava\\b", "oui çava bien", true},
+		{"^\\p{L}+$", "Größe", true},
+		{"^\\pL+$", "abc1", false},
+		{"^\\p{Greek}+$", "αβγ", true},
+		{"^\\p{Greek}+$", "abc", false},
+		{"^\\P{N}+$", "abc", true},
+		{"^\\P{N}+$", "a٣c", false},
+		{"^\\p{^L}$", "1", true},
+		{"^[\\p{Lu}\\d]+$", "ÀB12", true},
+		{"^\\x{65e5}$", "日", true},
+		{"^\\u00e9$", "é", true},
+		{"^é+$", "ééé", true},
+		{"^.$", "\xff", true},
+	}
+	for _, tt := range tests {
+		re, err := Compile(tt.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q) error: %v", tt.pattern, err)
+		}
+		got, _ := re.Match([]byte(tt.text))
+		if got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCompile_UnknownUnicodeClass(t *testing.T) {
+	for _, pat := range []string{"\\p{Klingon}", "\\p{L", "\\p", "\\pX"} {
+		if _, err := Compile(pat); err == nil {
+			t.Errorf("Compile(%q): expected error", pat)
+		}
+	}
+}
+
+func TestRegex_GroupKinds(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		want    bool
+	}{
+		{"^(?:ab)+$", "abab", true},
+		{"^(?:a|b)(c)\\1$", "acc", true},
+		{"^(?:a)(b)\\1$", "abb", true},
+		{"^(?P<word>\\w+) (?P=word)?", "hi hi", true},
+		{"^(?P<word>\\w+) \\k<word>$", "hey hey", true},
+		{"^(?P<word>\\w+) \\k<word>$", "hey you", false},
+		{"^(?<q>['\"]).*\\k<q>$", "'quoted'", true},
+		{"^(?<q>['\"]).*\\k<q>$", "'mixed\"", false},
+		{"^(?<a>x)(y)\\2\\k<a>$", "xyyx", true},
+		{"^(?>a+)b$", "aaab", true},
+		{"^(?>a+)a$", "aaa", false},
+		{"^(?>a|ab)c$", "abc", false},
+		{"^(?>ab|a)c$", "abc", true},
+	}
+	for _, tt := range tests {
+		re, err := Compile(tt.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q) error: %v", tt.pattern, err)
+		}
+		got, _ := re.Match([]byte(tt.text))
+		if got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRegex_SubexpNames(t *testing.T) {
+	re, err := Compile("(?P<year>\\d{4})-(\\d{2})(?:-(?<day>\\d{2}))?")
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+	want := []string{"", "year", "", "day"}
+	got := re.SubexpNames()
+	if len(got) != len(want) || re.NumSubexp() != 3 {
+		t.Fatalf("SubexpNames() = %q, NumSubexp() = %d", got, re.NumSubexp())
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("SubexpNames()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestCompile_InvalidGroups(t *testing.T) {
+	for _, pat := range []string{"(?P<1x>a)", "(?<a>x)(?<a>y)", "\\k<nope>", "\\kx", "(?<name", "(?Q)", "(?"} {
+		if _, err := Compile(pat); err == nil {
+			t.Errorf("Compile(%q): expected error", pat)
+		}
+	}
+}
+
+func TestRegex_Backreferences(t *testing.T) {
+	tenGroups := "(a)(b)(c)(d)(e)(f)(g)(h)(i)(j)"
+	tests := []struct {
+		pattern string
+		text    string
+		want    bool
+	}{
+		{"^" + tenGroups + "\\10$", "abcdefghijj", true},
+		{"^" + tenGroups + "\\10$", "abcdefghija0", false},
+		{"^" + tenGroups + "\\11$", "abcdefghija1", true},
+		{"^(a)\\12$", "aa2", true},
+		{"^(a)(b)\\g{2}\\g1$", "abba", true},
+		{"^(a)(b)\\g{-1}\\g{-2}$", "abba", true},
+		{"^(a)(b)\\g-1$", "abb", true},
+		{"^(x)(?:(y)\\g{-1})$", "xyy", true},
+		{"^(?<n>z)\\g{n}$", "zz", true},
+		{"^(?:(a)|(b))\\2$", "bb", true},
+		{"^(?:(a)|(b))\\2$", "ab", false},
+		{"^(a)|b\\1$", "b", false},
+	}
+	for _, tt := range tests {
+		re, err := Compile(tt.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q) error: %v", tt.pattern, err)
+		}
+		got, _ := re.Match([]byte(tt.text))
+		if got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCompile_InvalidBackreferences(t *testing.T) {
+	for _, pat := range []string{"\\1", "(a)\\2", "(a)\\g{3}", "\\g{-1}", "(a)\\g{-2}", "(a)\\g{0}", "(a)\\g", "(a)\\g{1"} {
+		if _, err := Compile(pat); err == nil {
+			t.Errorf("Compile(%q): expected error", pat)
+		}
+	}
+}
+
+func TestRegex_Lookaround(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		want    bool
+	}{
+		{"TODO(?!\\(#\\d+\\))", "TODO(#12) fix", false},
+		{"TODO(?!\\(#\\d+\\))", "TODO fix", true},
+		{"foo(?=bar)", "foobar", true},
+		{"foo(?=bar)", "foobaz", false},
+		{"^(?=.*\\d)(?=.*[a-z]).{6,}$", "abc123", true},
+		{"^(?=.*\\d)(?=.*[a-z]).{6,}$", "abcdef", false},
+		{"(?<!#)password=", "password=hunter2", true},
+		{"(?<!#)password=", "#password=hunter2", false},
+		{"(?<!# ?)password=", "# password=x", false},
+		{"(?<=\\$)\\d+", "cost: $42", true},
+		{"(?<=\\$)\\d+", "cost: 42", false},
+		{"(?<=é)x", "éx", true},
+		{"(?<=ab|c)d", "cd", true},
+		{"(?<=ab|c)d", "bd", false},
+		{"^(?=(a+))\\1b$", "aab", true},
+		{"^(?!(a))\\w\\1?$", "b", true},
+		{"\\b(?<=\\s)x", " x", true},
+	}
+	for _, tt := range tests {
+		re, err := Compile(tt.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q) error: %v", tt.pattern, err)
+		}
+		got, _ := re.Match([]byte(tt.text))
+		if got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCompile_UnboundedLookbehind(t *testing.T) {
+	for _, pat := range []string{"(?<=a+)b", "(?<!a*)b", "(a)(?<=\\1)b", "(?<=a{2,})b"} {
+		if _, err := Compile(pat); err == nil {
+			t.Errorf("Compile(%q): expected error", pat)
+		}
+	}
+}
+
+func TestRegex_InlineFlags(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		want    bool
+	}{
+		{"(?i)hello", "HeLLo", true},
+		{"hello", "HeLLo", false},
+		{"(?i)straße", "STRAẞE", true},
+		{"(?i)σοφια", "ΣΟΦΙΑ", true},
+		{"(?i)k", "K", true},
+		{"(?i)^[a-c]+$", "AbC", true},
+		{"(?i)^[^a]$", "A", false},
+		{"(?i)^\\p{Lu}+$", "abc", true},
+		{"(?i)^(ab)\\1$", "abAB", true},
+		{"^(ab)\\1$", "abAB", false},
+		{"a(?i)b", "aB", true},
+		{"a(?i)b", "AB", false},
+		{"(?i:a)b", "Ab", true},
+		{"(?i:a)b", "AB", false},
+		{"(a(?i)b)c", "aBc", true},
+		{"(a(?i)b)c", "aBC", false},
+		{"(?i)a(?-i)b", "Ab", true},
+		{"(?i)a(?-i)b", "AB", false},
+		{"(?i)a(?-i:b)c", "AbC", true},
+		{"^a.c$", "a\nc", false},
+		{"(?s)^a.c$", "a\nc", true},
+		{"^two$", "one\ntwo\nthree", false},
+		{"(?m)^two$", "one\ntwo\nthree", true},
+		{"(?m)^one$", "one\ntwo", true},
+		{"(?ms)^one.two$", "one\ntwo", true},
+		{"(?x) a b  c # comment", "abc", true},
+		{"(?x) a \\  b", "a b", true},
+		{"(?x)[ ]x", " x", true},
+		{"(?x)a +", "aaa", true},
+		{"(?x:a b)c d", "abc d", true},
+	}
+	for _, tt := range tests {
+		re, err := Compile(tt.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q) error: %v", tt.pattern, err)
+		}
+		got, _ := re.Match([]byte(tt.text))
+		if got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCompile_InvalidFlags(t *testing.T) {
+	for _, pat := range []string{"(?)", "(?z)", "(?i-)", "(?i-m-s)", "(?i", "(?-:a)"} {
+		if _, err := Compile(pat); err == nil {
+			t.Errorf("Compile(%q): expected error", pat)
+		}
+	}
+}
+
+func TestCompile_SelectsEngine(t *testing.T) {
+	tests := []struct {
+		pattern string
+		nfa     bool
+	}{
+		{"a+b*c", true},
+		{"(?i)(foo|bar){2,3}?\\b", true},
+		{"(a)\\1", false},
+		{"a(?=b)", false},
+		{"(?>a+)", false},
+		{"a++", false},
+	}
+	for _, tt := range tests {
+		re, err := Compile(tt.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q) error: %v", tt.pattern, err)
+		}
+		if (re.prog != nil) != tt.nfa {
+			t.Errorf("Compile(%q): NFA engine = %v, want %v", tt.pattern, re.prog != nil, tt.nfa)
+		}
+	}
+}
+
+func TestRegex_EnginesAgree(t *testing.T) {
+	patterns := []string{
+		"a", "^hello$", "h.llo", "[abc]+", "[^abc]+", "a+b", "ab?c", "(ab)+c",
+		"a|b", "^(foo|bar)+[abc]?$", "ab*c", "^a{2,3}$", "^(ab){2}$", "a{,2}",
+		"^a+?b$", "^a*?$", "\\bcat\\b", "\\Bcat\\B", "\\<cat\\>", "^\\p{L}+$", "(?i)straße",
+		"(?m)^two$", "(?s)^a.c$", "^(a*)*$", "^(a|ab)(c|bcd)(d*)$", "x*", "$", "",
+		"(|a)?", "(a*)?b", "(|a){0,2}$", "(|a){1,3}", "(a|)*c", "(a*){2,}", "(|a)??b",
+		"(?:a*?)*", "(?:[^a]*?)*", "(?:a*?){2,}", "(?:(?:a*?){2,}(a)*)", "((?:a|)*?){0,2}b",
+	}
+	texts := []string{
+		"", "a", "b", "hello", "hallo", "cab", "def", "aaab", "abc", "ac", "ababc",
+		"foobarc", "abbbc", "aaa", "abab", "a{,2}", "the cat sat", "concatenate",
+		"Größe", "STRASSE", "STRAẞE", "one\ntwo\nthree", "a\nc", "abcd", "xx",
+		"aab", "aac", "aa", "bcd",
+	}
+	for _, longest := range []bool{false, true} {
+		for _, pat := range patterns {
+			re, err := Compile(pat)
+			if err != nil {
+				t.Fatalf("Compile(%q) error: %v", pat, err)
+			}
+			if re.prog == nil {
+				t.Fatalf("Compile(%q): expected NFA engine", pat)
+			}
+			re.longest = longest
+			bt := *re
+			bt.prog = nil
+			for _, text := range texts {
+				nfa := re.FindAllSubmatchIndex([]byte(text), -1)
+				want := bt.FindAllSubmatchIndex([]byte(text), -1)
+				if fmt.Sprint(nfa) != fmt.Sprint(want) {
+					t.Errorf("FindAllSubmatchIndex(%q, %q) longest=%v: NFA %v, backtracker %v", pat, text, longest, nfa, want)
+				}
+			}
+		}
+	}
+}
+
+func TestCompilePOSIX_LeftmostLongest(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		first   []int
+		longest []int
+	}{
+		{"a|ab", "xab", []int{1, 2}, []int{1, 3}},
+		{"(a|ab)(c|bcd)", "abcd", []int{0, 4}, []int{0, 4}},
+		{"a*?", "aaa", []int{0, 0}, []int{0, 3}},
+		{"(foo|foobar)baz?", "foobarbaz", []int{0, 5}, []int{0, 9}},
+		{"\\w+|\\w+ \\w+", "hello world", []int{0, 5}, []int{0, 11}},
+		{"b|ab|abc", "xabcab", []int{1, 3}, []int{1, 4}},
+		{"(?=a)a|(?=a)ab", "ab", []int{0, 1}, []int{0, 2}},
+		{"(a)\\1|(a)\\2a", "aaa", []int{0, 2}, []int{0, 3}},
+		{"q", "abc", nil, nil},
+	}
+	for _, tt := range tests {
+		re := MustCompile(tt.pattern)
+		if got := re.FindIndex([]byte(tt.text)); !reflect.DeepEqual(got, tt.first) {
+			t.Errorf("Compile(%q).FindIndex(%q) = %v, want %v", tt.pattern, tt.text, got, tt.first)
+		}
+		re = MustCompilePOSIX(tt.pattern)
+		if got := re.FindIndex([]byte(tt.text)); !reflect.DeepEqual(got, tt.longest) {
+			t.Errorf("CompilePOSIX(%q).FindIndex(%q) = %v, want %v", tt.pattern, tt.text, got, tt.longest)
+		}
+		if ok, _ := re.Match([]byte(tt.text)); ok != (tt.longest != nil) {
+			t.Errorf("CompilePOSIX(%q).Match(%q) = %v", tt.pattern, tt.text, ok)
+		}
+	}
+
+	re := MustCompile("a|ab")
+	re.Longest()
+	if got := re.ReplaceAllLiteral([]byte("ab ab"), []byte("X")); string(got) != "X X" {
+		t.Errorf("Longest ReplaceAllLiteral = %q, want %q", got, "X X")
+	}
+}
+
+func TestRegex_BacktrackerLongText(t *testing.T) {
+	// The backtracker keeps its state on the heap, so a stack limit far
+	// below what recursing on every character would take is enough.
+	defer debug.SetMaxStack(debug.SetMaxStack(1 << 20))
+	text := "bb" + strings.Repeat("a", 200000)
+	re := MustCompile(`(b)\1.*$`)
+	if re.prog != nil {
+		t.Fatalf("expected the backtracker")
+	}
+	if got := re.FindIndex([]byte(text)); !reflect.DeepEqual(got, []int{0, len(text)}) {
+		t.Errorf("FindIndex = %v, want [0 %d]", got, len(text))
+	}
+	if got := MustCompilePOSIX(`(a|b)\1(a|ab)*$`).FindIndex([]byte(text)); !reflect.DeepEqual(got, []int{0, len(text)}) {
+		t.Errorf("longest FindIndex = %v, want [0 %d]", got, len(text))
+	}
+}
+
+func TestRegex_PathologicalPatternIsLinear(t *testing.T) {
+	re, err := Compile("^(a|a)*(a*)*c$")
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+	// The c keeps the prefilter, which requires one, from rejecting the
+	// text before an engine runs.
+	text := []byte(strings.Repeat("a", 2500) + "c" + strings.Repeat("a", 2500))
+	if re.pre != nil && re.pre.rejects(text, 0) {
+		t.Fatalf("prefilter rejects the text")
+	}
+	if ok, _ := re.Match(text); ok {
+		t.Fatalf("unexpected match")
+	}
+}
+
+func TestRegex_FindIndex(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		want    []int
+	}{
+		{"b+", "abbbc", []int{1, 4}},
+		{"x", "abc", nil},
+		{"", "abc", []int{0, 0}},
+		{"a|ab", "ab", []int{0, 1}},
+		{"ab|a", "ab", []int{0, 2}},
+		{"é+", "caféé!", []int{3, 7}},
+		{"(a)\\1", "xaay", []int{1, 3}},
+		{"\\d+(?= USD)", "cost 42 USD", []int{5, 7}},
+	}
+	for _, tt := range tests {
+		re, err := Compile(tt.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q) error: %v", tt.pattern, err)
+		}
+		if got := re.FindIndex([]byte(tt.text)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("FindIndex(%q, %q) = %v, want %v", tt.pattern, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRegex_FindSubmatch(t *testing.T) {
+	re, _ := Compile("(?P<key>\\w+)=(\\d+)?(x)?")
+	got := re.FindSubmatchIndex([]byte("  port=8080"))
+	want := []int{2, 11, 2, 6, 7, 11, -1, -1}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("FindSubmatchIndex = %v, want %v", got, want)
+	}
+	sub := re.FindSubmatch([]byte("  port=8080"))
+	if string(sub[0]) != "port=8080" || string(sub[1]) != "port" || string(sub[2]) != "8080" || sub[3] != nil {
+		t.Fatalf("FindSubmatch = %q", sub)
+	}
+	if re.FindSubmatch([]byte("nothing")) != nil {
+		t.Fatalf("FindSubmatch: expected nil")
+	}
+
+	reBT, _ := Compile("(\\w)(\\w)\\2\\1")
+	if got := reBT.FindSubmatchIndex([]byte("xabba")); !reflect.DeepEqual(got, []int{1, 5, 1, 2, 2, 3}) {
+		t.Fatalf("backtracker FindSubmatchIndex = %v", got)
+	}
+}
+
+func TestRegex_FindAll(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		n       int
+		want    []string
+	}{
+		{"\\d+", "a1b22c333", -1, []string{"1", "22", "333"}},
+		{"\\d+", "a1b22c333", 2, []string{"1", "22"}},
+		{"x*", "axxb", -1, []string{"", "xx", ""}},
+		{"", "é!", -1, []string{"", "", ""}},
+		{"\\bw", "wow we", -1, []string{"w", "w"}},
+		{"^a", "aaa", -1, []string{"a"}},
+		{"q", "abc", -1, nil},
+	}
+	for _, tt := range tests {
+		re, err := Compile(tt.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q) error: %v", tt.pattern, err)
+		}
+		var got []string
+		for _, m := range re.FindAll([]byte(tt.text), tt.n) {
+			got = append(got, string(m))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("FindAll(%q, %q, %d) = %q, want %q", tt.pattern, tt.text, tt.n, got, tt.want)
+		}
+	}
+
+	re, _ := Compile("(\\w+)@(\\w+)")
+	all := re.FindAllSubmatch([]byte("a@b, cc@dd"), -1)
+	if len(all) != 2 || string(all[1][1]) != "cc" || string(all[1][2]) != "dd" {
+		t.Fatalf("FindAllSubmatch = %q", all)
+	}
+	idx := re.FindAllIndex([]byte("a@b, cc@dd"), -1)
+	if !reflect.DeepEqual(idx, [][]int{{0, 3}, {5, 10}}) {
+		t.Fatalf("FindAllIndex = %v", idx)
+	}
+}
+
+func TestRegex_ReplaceAll(t *testing.T) {
+	tests := []struct {
+		pattern string
+		src     string
+		repl    string
+		want    string
+	}{
+		{"a(x*)b", "-ab-axxb-", "${1}W", "-W-xxW-"},
+		{"a(x*)b", "-ab-axxb-", "$1W", "---"},
+		{"a(x*)b", "-ab-axxb-", "$$1", "-$1-$1-"},
+		{"(?P<k>\\w+)=(?P<v>\\w+)", "a=1 b=2", "$v=$k", "1=a 2=b"},
+		{"(?P<k>\\w+)=(?P<v>\\w+)", "a=1", "${v}${nope}${2}$0", "11a=1"},
+		{"x*", "abc", "-", "-a-b-c-"},
+		{"(a)|(b)", "ab", "[$1$2]", "[a][b]"},
+		{"b", "abc", "${1", "a${1c"},
+		{"(\\w)\\1", "aabcc", "<$1>", "<a>b<c>"},
+	}
+	for _, tt := range tests {
+		re, err := Compile(tt.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q) error: %v", tt.pattern, err)
+		}
+		if got := string(re.ReplaceAll([]byte(tt.src), []byte(tt.repl))); got != tt.want {
+			t.Errorf("ReplaceAll(%q, %q, %q) = %q, want %q", tt.pattern, tt.src, tt.repl, got, tt.want)
+		}
+	}
+}
+
+func TestRegex_ReplaceAllFuncAndLiteral(t *testing.T) {
+	re, _ := Compile("[a-z]+")
+	got := re.ReplaceAllFunc([]byte("hi there 42"), bytes.ToUpper)
+	if string(got) != "HI THERE 42" {
+		t.Fatalf("ReplaceAllFunc = %q", got)
+	}
+	if got := re.ReplaceAllLiteral([]byte("a1b"), []byte("$0")); string(got) != "$01$0" {
+		t.Fatalf("ReplaceAllLiteral = %q", got)
+	}
+}
+
+func TestRegex_Expand(t *testing.T) {
+	re, _ := Compile("(?P<user>\\w+)@(?P<host>\\w+)")
+	src := []byte("mail bob@example now")
+	match := re.FindSubmatchIndex(src)
+	got := re.Expand([]byte("to: "), []byte("$host/$user"), src, match)
+	if string(got) != "to: example/bob" {
+		t.Fatalf("Expand = %q", got)
+	}
+}
+
+func TestRegex_FindReaderIndex(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+	}{
+		{"b+", "aabbbcc"},
+		{"^two$", "one\ntwo"},
+		{"(?m)^two$", "one\ntwo\nthree"},
+		{"\\bcat\\b", "concat cat"},
+		{"o\\nt", "one\ntwo"},
+		{"x*", "abc"},
+		{"$", "abc"},
+		{"é+", "café éé"},
+		{"(a)\\1", "xaay"},
+		{"q(?=u)", "qi qu"},
+		{"z", "abc"},
+		{".", "\xffa"},
+	}
+	for _, tt := range tests {
+		for _, posix := range []bool{false, true} {
+			re := MustCompile(tt.pattern)
+			if posix {
+				re = MustCompilePOSIX(tt.pattern)
+			}
+			want := re.FindIndex([]byte(tt.text))
+			got, err := re.FindReaderIndex(strings.NewReader(tt.text))
+			if err != nil || !reflect.DeepEqual(got, want) {
+				t.Errorf("FindReaderIndex(%q, %q) = %v, %v, want %v", tt.pattern, tt.text, got, err, want)
+			}
+			ok, err := re.MatchReader(bufio.NewReader(strings.NewReader(tt.text)))
+			if err != nil || ok != (want != nil) {
+				t.Errorf("MatchReader(%q, %q) = %v, %v, want %v", tt.pattern, tt.text, ok, err, want != nil)
+			}
+		}
+	}
+}
+
+// countingReader counts the runes read through it.
+type countingReader struct {
+	r io.RuneReader
+	n int
+}
+
+func (c *countingReader) ReadRune() (rune, int, error) {
+	c.n++
+	return c.r.ReadRune()
+}
+
+func TestRegex_FindReaderIndexLongLineBacktracker(t *testing.T) {
+	// A single huge line read from a stream by a pattern that needs the
+	// backtracker costs memory but must not grow the stack.
+	defer debug.SetMaxStack(debug.SetMaxStack(1 << 20))
+	text := "bb" + strings.Repeat("a", 200000) + "\n"
+	re := MustCompile(`(?m)(b)\1a*$`)
+	loc, err := re.FindReaderIndex(bufio.NewReader(strings.NewReader(text)))
+	if err != nil || !reflect.DeepEqual(loc, []int{0, len(text) - 1}) {
+		t.Errorf("FindReaderIndex = %v, %v; want [0 %d]", loc, err, len(text)-1)
+	}
+}
+
+func TestRegex_MatchReaderStopsEarly(t *testing.T) {
+	re := MustCompile("needle")
+	text := "hay needle " + strings.Repeat("hay ", 100000)
+	cr := &countingReader{r: strings.NewReader(text)}
+	if ok, err := re.MatchReader(cr); !ok || err != nil {
+		t.Fatalf("MatchReader = %v, %v, want true, nil", ok, err)
+	}
+	if cr.n > 20 {
+		t.Errorf("MatchReader read %d runes, want it to stop after the match", cr.n)
+	}
+}
+
+func TestRegex_MatchReaderError(t *testing.T) {
+	boom := errors.New("boom")
+	for _, pat := range []string{"a", "(a)\\1"} {
+		re := MustCompile(pat)
+		r := bufio.NewReader(io.MultiReader(strings.NewReader("xyz"), iotest.ErrReader(boom)))
+		if _, err := re.FindReaderIndex(r); !errors.Is(err, boom) {
+			t.Errorf("FindReaderIndex(%q) error = %v, want %v", pat, err, boom)
+		}
+	}
+}
+
+func TestParse_FoldedLiteral(t *testing.T) {
+	tests := []struct {
+		pattern string
+		op      nodeOp
+		ranges  []rune
+	}{
+		{"k", opClass, []rune{'K', 'K', 'k', 'k', '\u212A', '\u212A'}},
+		{"1", opLiteral, nil},
+	}
+	for _, tt := range tests {
+		root, _, err := parse(tt.pattern, Options{IgnoreCase: true})
+		if err != nil {
+			t.Fatalf("parse(%q) error: %v", tt.pattern, err)
+		}
+		if root.op != tt.op || tt.ranges != nil && !reflect.DeepEqual(root.class.ranges, tt.ranges) {
+			t.Errorf("parse(%q) = op %d, class %v; want op %d, ranges %q", tt.pattern, root.op, root.class, tt.op, tt.ranges)
+		}
+	}
+}
+
+func TestAnalyze_Literals(t *testing.T) {
+	tests := []struct {
+		pattern string
+		prefix  []string
+		suffix  []string
+		inner   string
+	}{
+		{"hello", []string{"hello"}, []string{"hello"}, "hello"},
+		{"foo\\d+bar", []string{"foo0", "foo1", "foo2", "foo3", "foo4", "foo5", "foo6", "foo7", "foo8", "foo9"}, []string{"0bar", "1bar", "2bar", "3bar", "4bar", "5bar", "6bar", "7bar", "8bar", "9bar"}, "foo"},
+		{"(cat|dog)s?", []string{"cat", "cats", "dog", "dogs"}, []string{"cat", "cats", "dog", "dogs"}, ""},
+		{"^func \\w+\\(", []string{"func "}, []string{"("}, "func "},
+		{"x*yz", nil, []string{"yz"}, "yz"},
+		{"a.c", []string{"a"}, []string{"c"}, "a"},
+		{"(?i)abc", []string{"ABC", "ABc", "AbC", "Abc", "aBC", "aBc", "abC", "abc"}, []string{"ABC", "ABc", "AbC", "Abc", "aBC", "aBc", "abC", "abc"}, ""},
+		{"(?i)a1-2", []string{"A1-2", "a1-2"}, []string{"A1-2", "a1-2"}, ""},
+		{"[^a]bc", nil, []string{"bc"}, "bc"},
+		{"ab{2}c", []string{"abbc"}, []string{"abbc"}, "abbc"},
+		{"\\w+@\\w+", nil, nil, "@"},
+		{"a?", nil, nil, ""},
+	}
+	for _, tt := range tests {
+		root, _, err := parse(tt.pattern, Options{})
+		if err != nil {
+			t.Fatalf("parse(%q) error: %v", tt.pattern, err)
+		}
+		info := analyze(root)
+		if !reflect.DeepEqual(info.prefix, tt.prefix) || !reflect.DeepEqual(info.suffix, tt.suffix) || info.inner != tt.inner {
+			t.Errorf("analyze(%q) = prefix %q, suffix %q, inner %q; want %q, %q, %q",
+				tt.pattern, info.prefix, info.suffix, info.inner, tt.prefix, tt.suffix, tt.inner)
+		}
+	}
+}
+
+func TestAhoCorasick_EarliestStart(t *testing.T) {
+	tests := []struct {
+		lits []string
+		text string
+		want int
+	}{
+		{[]string{"he", "she", "his", "hers"}, "ushers", 1},
+		{[]string{"bcd", "abcde"}, "xabcde", 1},
+		{[]string{"cd", "abcdefg"}, "abcdefg", 0},
+		{[]string{"foo", "bar"}, "bazqux", -1},
+		{[]string{"a", "ab"}, "", -1},
+		{[]string{"é", "ü"}, "naïve über", 7},
+	}
+	for _, tt := range tests {
+		if got := newAhoCorasick(tt.lits, false).index([]byte(tt.text)); got != tt.want {
+			t.Errorf("index(%q, %q) = %d, want %d", tt.lits, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRegex_PrefilterAgrees(t *testing.T) {
+	patterns := []string{
+		"hello", "foo\\d+bar", "(cat|dog)s?", "^func \\w+\\(", "x*yz", "a.c",
+		"(?i)abc", "[^a]bc", "\\w+@\\w+", "(a)\\1b", "(?<=a)b", "\\bcat\\b",
+		"(?m)^two$", "b|ab|abc", "é+", "[ab]c",
+	}
+	texts := []string{
+		"", "hello world", "foo12bar foobar", "cats and dogs", "func main(", "xxyz yz",
+		"abc a\nc", "ABC", "xbc abc", "me@host", "aab ab", "bob b", "concat cat",
+		"one\ntwo", "xabcab", "café éé", "ac bc cc",
+	}
+	for _, posix := range []bool{false, true} {
+		for _, pat := range patterns {
+			re := MustCompile(pat)
+			re.longest = posix
+			plain := *re
+			plain.pre = nil
+			for _, text := range texts {
+				got := re.FindAllSubmatchIndex([]byte(text), -1)
+				want := plain.FindAllSubmatchIndex([]byte(text), -1)
+				if fmt.Sprint(got) != fmt.Sprint(want) {
+					t.Errorf("FindAllSubmatchIndex(%q, %q) longest=%v: prefiltered %v, plain %v", pat, text, posix, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestQuoteMeta(t *testing.T) {
+	for _, s := range []string{"", "a.b", `1+1=2? [yes] {no} (a|b) ^x$ \d*`, "héllo.wörld"} {
+		q := QuoteMeta(s)
+		re, err := Compile("^" + q + "$")
+		if err != nil {
+			t.Fatalf("Compile(%q) error: %v", q, err)
+		}
+		if ok, _ := re.Match([]byte(s)); !ok {
+			t.Errorf("QuoteMeta(%q) = %q does not match the input", s, q)
+		}
+	}
+	if got := QuoteMeta("a.b*c"); got != `a\.b\*c` {
+		t.Errorf("QuoteMeta = %q", got)
+	}
+}
+
+func TestRegex_LiteralSetAgrees(t *testing.T) {
+	patterns := []string{"abc", "a|ab", "ab|a", "he|she|his|hers", "bcd|abcde", "x\\.y|x", "é|café",
+		"(?i)ab|cd", "(?i)a|ab", "(?i)straße|k", "[ab]c|d", "(?i)a(?-i)b|cd", "(?i)x1|é"}
+	texts := []string{"", "abc", "ab a", "ushers his", "xabcde bcd", "x.y xzy", "café é", "nothing",
+		"AB aB Cd", "STRAẞE \u212A", "bc ac dc", "X1 ÉAb", "ſtraSSe"}
+	for _, longest := range []bool{false, true} {
+		for _, pat := range patterns {
+			re := MustCompile(pat)
+			if re.lits == nil {
+				t.Fatalf("Compile(%q): expected literal set", pat)
+			}
+			re.longest = longest
+			plain := *re
+			plain.lits = nil
+			for _, text := range texts {
+				got := re.FindAllIndex([]byte(text), -1)
+				want := plain.FindAllIndex([]byte(text), -1)
+				if fmt.Sprint(got) != fmt.Sprint(want) {
+					t.Errorf("FindAllIndex(%q, %q) longest=%v: literal set %v, engine %v", pat, text, longest, got, want)
+				}
+			}
+		}
+	}
+	for _, pat := range []string{"a|", "(a|b)", "[^a]b|cd", "a|b.", "\\bab|cd", "(?i)αβγδεζηθι"} {
+		if MustCompile(pat).lits != nil {
+			t.Errorf("Compile(%q): unexpected literal set", pat)
+		}
+	}
+}
+
+func TestLiteralList_Fold(t *testing.T) {
+	tests := []struct {
+		pattern string
+		opts    Options
+		lits    []string
+		fold    bool
+	}{
+		{"straße|k", Options{IgnoreCase: true}, []string{"straße", "straẞe", "ſtraße", "ſtraẞe", "k", "\u212A"}, true},
+		{"Tok|1", Options{IgnoreCase: true}, []string{"tok", "to\u212A", "1"}, true},
+		{"[aA]b|c", Options{}, []string{"Ab", "ab", "c"}, false},
+		{"(?i)a(?-i)b", Options{}, []string{"Ab", "ab"}, false},
+		{"12|3", Options{IgnoreCase: true}, []string{"12", "3"}, false},
+	}
+	for _, tt := range tests {
+		root, _, err := parse(tt.pattern, tt.opts)
+		if err != nil {
+			t.Fatalf("parse(%q) error: %v", tt.pattern, err)
+		}
+		if lits, fold := literalList(root); !reflect.DeepEqual(lits, tt.lits) || fold != tt.fold {
+			t.Errorf("literalList(%q) = %q, %v; want %q, %v", tt.pattern, lits, fold, tt.lits, tt.fold)
+		}
+	}
+}
+
+func TestAnalyze_ShortenedPrefixes(t *testing.T) {
+	root, _, err := parse("abcdefgh|lmnopqru", Options{IgnoreCase: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each alternative has 256 case variants; cut to seven letters they
+	// fit in one set.
+	prefix := analyze(root).prefix
+	if len(prefix) != 256 || prefix[0] != "ABCDEFG" || prefix[255] != "lmnopqr" {
+		t.Errorf("prefix = %d strings from %q to %q, want 256 from ABCDEFG to lmnopqr", len(prefix), prefix[0], prefix[len(prefix)-1])
+	}
+}
+
+func TestCompileList(t *testing.T) {
+	re, err := CompileList([]string{"(a)\\1", "(?P<x>b)\\k<x>", "(?i)c"}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := re.FindAll([]byte("ab aa bb C c"), -1); fmt.Sprintf("%q", got) != `["aa" "bb" "C" "c"]` {
+		t.Errorf("FindAll = %q", got)
+	}
+	if got := re.FindSubmatchIndex([]byte("xbb")); !reflect.DeepEqual(got, []int{1, 3, -1, -1, 1, 2}) {
+		t.Errorf("FindStringSubmatchIndex = %v, want [1 3 -1 -1 1 2]", got)
+	}
+	if got := re.SubexpNames(); !reflect.DeepEqual(got, []string{"", "", "x"}) {
+		t.Errorf("SubexpNames = %q", got)
+	}
+
+	if set, _ := CompileList([]string{"a", "b|c"}, Options{}); set.lits == nil {
+		t.Errorf("CompileList of literals: expected literal set")
+	}
+	if empty, _ := CompileList(nil, Options{}); empty.FindIndex([]byte("a")) != nil {
+		t.Errorf("empty list matches")
+	}
+
+	_, err = CompileList([]string{"ok", "a(b"}, Options{})
+	var serr *SyntaxError
+	if !errors.As(err, &serr) || serr.Pattern != "a(b" {
+		t.Errorf("CompileList error = %v, want a syntax error in %q", err, "a(b")
+	}
+}
+
+func TestRegex_ManyLiterals(t *testing.T) {
+	var quoted []string
+	for i := 0; i < 5000; i++ {
+		quoted = append(quoted, QuoteMeta(fmt.Sprintf("tok_%05d.id", i)))
+	}
+	re := MustCompilePOSIX(strings.Join(quoted, "|"))
+	text := []byte(strings.Repeat("no tokens here, ", 1000) + "leaked tok_04321.id!")
+	if got, want := re.FindIndex(text), []int{16007, 16019}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FindIndex = %v, want %v", got, want)
+	}
+}
+
+func TestCompileBRE_MatchesEquivalentERE(t *testing.T) {
+	tests := []struct {
+		bre, ere string
+	}{
+		{`\(ab\)\{2\}`, `(ab){2}`},
+		{`a\{2,\}b\{,3\}`, `a{2,}b{0,3}`},
+		{`a\|b\|c`, `a|b|c`},
+		{`a\+b\?`, `a+b?`},
+		{`(a)`, `\(a\)`},
+		{`a|b`, `a\|b`},
+		{`a+?`, `a\+\?`},
+		{`a{2}`, `a\{2\}`},
+		{`*a`, `\*a`},
+		{`\(*a\)`, `(\*a)`},
+		{`a\|*b`, `a|\*b`},
+		{`^*a`, `^\*a`},
+		{`a^b$c`, `a\^b\$c`},
+		{`^a$`, `^a$`},
+		{`\(^a$\)`, `(^a$)`},
+		{`a\|^b$\|c`, `a|^b$|c`},
+		{`\([a-z]*\)=\1`, `([a-z]*)=\1`},
+	}
+	for _, tt := range tests {
+		bre, err := CompileBRE(tt.bre)
+		if err != nil {
+			t.Fatalf("CompileBRE(%q) error: %v", tt.bre, err)
+		}
+		ere, err := Compile(tt.ere)
+		if err != nil {
+			t.Fatalf("Compile(%q) error: %v", tt.ere, err)
+		}
+		if !reflect.DeepEqual(bre.root, ere.root) || bre.ncap != ere.ncap {
+			t.Errorf("CompileBRE(%q) does not parse like Compile(%q)", tt.bre, tt.ere)
+		}
+	}
+}
+
+func TestCompileBRE_Matching(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		want    []int
+	}{
+		{`fo\+`, "afooo", []int{1, 5}},
+		{`f(o)`, "fo f(o)", []int{3, 7}},
+		{`\(ab\)*c`, "xababc", []int{1, 6}},
+		{`a\|ab`, "ab", []int{0, 2}},
+		{`\(a\)\1`, "baab", []int{1, 3}},
+		{`1+1`, "1+1=2", []int{0, 3}},
+		{`^\*`, "*x", []int{0, 1}},
+		{`$5`, "costs $5", []int{6, 8}},
+		{`^^caret`, "^caret", []int{0, 6}},
+		{`^^`, "x^", nil},
+		{`[\]`, `a\b`, []int{1, 2}},
+		{`[\d]*`, `\dd1`, []int{0, 3}},
+		{`[^\]x`, `\x yx`, []int{3, 5}},
+		{`\<the\>`, "other the", []int{6, 9}},
+	}
+	for _, tt := range tests {
+		re, err := CompileBRE(tt.pattern)
+		if err != nil {
+			t.Fatalf("CompileBRE(%q) error: %v", tt.pattern, err)
+		}
+		if got := re.FindIndex([]byte(tt.text)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("CompileBRE(%q).FindIndex(%q) = %v, want %v", tt.pattern, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCompileBRE_Errors(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		offset   int
+		fragment string
+	}{
+		{`\(a`, 0, `\(a`},
+		{`a\)`, 1, `\)`},
+		{`a\|\{1\}`, 3, `\{1\}`},
+		{`a\{3,1\}`, 1, `\{3,1\}`},
+		{`x\{2`, 1, `\{2`},
+		{`x\{2,`, 1, `\{2,`},
+		{`x\{a\}y`, 1, `\{a\}`},
+	}
+	for _, tt := range tests {
+		_, err := CompileBRE(tt.pattern)
+		serr, ok := err.(*SyntaxError)
+		if !ok || serr.Offset != tt.offset || serr.Fragment != tt.fragment {
+			t.Errorf("CompileBRE(%q) error = %v, want offset %d fragment %q", tt.pattern, err, tt.offset, tt.fragment)
+		}
+	}
+}
+
+func TestCompileOptions_IgnoreCase(t *testing.T) {
+	re, err := CompileOptions(`straße\|x(y)`, Options{BRE: true, IgnoreCase: true})
+	if err != nil {
+		t.Fatalf("CompileOptions error: %v", err)
+	}
+	if got := re.FindAll([]byte("STRASSE STRAẞE X(Y)"), -1); fmt.Sprintf("%q", got) != `["STRAẞE" "X(Y)"]` {
+		t.Errorf("FindAll = %q", got)
+	}
+	re, err = CompileOptions(`a(?-i)b`, Options{IgnoreCase: true})
+	if err != nil {
+		t.Fatalf("CompileOptions error: %v", err)
+	}
+	for text, want := range map[string]bool{"Ab": true, "AB": false} {
+		if ok, _ := re.Match([]byte(text)); ok != want {
+			t.Errorf("Match(%q) = %v, want %v", text, ok, want)
+		}
+	}
+}
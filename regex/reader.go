@@ -0,0 +1,71 @@
+package regex
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// MatchReader reports whether the text read from r contains a match. It
+// stops reading as soon as the answer is known, so r may be left partway
+// through. Patterns that run on the linear-time engine are matched as the
+// text streams in, without buffering it, which suits very long lines and
+// patterns that span lines. Patterns that need the backtracker read all of
+// r into memory first. Their memory use then grows with the length of the
+// text: the text itself plus up to a few words of backtracking state per
+// character. The state lives on the heap, so a long stream never exhausts
+// the goroutine stack. The error is the first read error other than
+// io.EOF.
+func (re *Regex) MatchReader(r io.RuneReader) (bool, error) {
+	loc, err := re.readerExecute(r, 0, true)
+	return loc != nil, err
+}
+
+// FindReaderIndex returns the start and end byte offsets of the leftmost
+// match in the text read from r, or nil if there is no match. Reading
+// stops once the match is known, as for MatchReader. Invalid UTF-8 is
+// counted as reported by r, which for a bufio.Reader is one byte per
+// utf8.RuneError.
+func (re *Regex) FindReaderIndex(r io.RuneReader) ([]int, error) {
+	return re.readerExecute(r, 2, false)
+}
+
+// readerExecute is execute for a stream. The backtracker needs random
+// access to the text, so for patterns that use it the stream is read in
+// full and searched like a byte slice.
+func (re *Regex) readerExecute(r io.RuneReader, nslots int, any bool) ([]int, error) {
+	if re.prog == nil {
+		text, err := readRunes(r)
+		if err != nil {
+			return nil, err
+		}
+		return re.execute(text, 0, nslots, any), nil
+	}
+	in := newInputReader(r)
+	loc := re.runVM(in, 0, nslots, any)
+	if in.err != nil {
+		return nil, in.err
+	}
+	return loc, nil
+}
+
+// readRunes reads r to the end and returns its text encoded as UTF-8.
+// A one-byte utf8.RuneError stands for an invalid byte whose value is
+// lost, so it is written as 0xFF, which decodes the same way and keeps
+// the offsets of later characters unchanged.
+func readRunes(r io.RuneReader) ([]byte, error) {
+	var text []byte
+	for {
+		c, w, err := r.ReadRune()
+		if err == io.EOF {
+			return text, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c == utf8.RuneError && w == 1 {
+			text = append(text, 0xFF)
+			continue
+		}
+		text = utf8.AppendRune(text, c)
+	}
+}
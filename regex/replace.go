@@ -0,0 +1,133 @@
+package regex
+
+import "bytes"
+
+// ReplaceAll returns a copy of src with every match replaced by repl.
+// Inside repl, $1 or ${1} stands for the text of group 1 and $name or
+// ${name} for the text of a named group, with $0 for the whole match. A
+// name is taken to be as long as possible, so $1x means ${1x}, not ${1}x.
+// Use $$ for a literal dollar sign. References to groups that do not
+// exist or did not take part in the match expand to nothing.
+func (re *Regex) ReplaceAll(src, repl []byte) []byte {
+	return re.replaceAll(src, 2*(re.ncap+1), func(dst []byte, match []int) []byte {
+		return re.Expand(dst, repl, src, match)
+	})
+}
+
+// ReplaceAllLiteral returns a copy of src with every match replaced by
+// repl, which is used as-is without expanding $ references.
+func (re *Regex) ReplaceAllLiteral(src, repl []byte) []byte {
+	return re.replaceAll(src, 2, func(dst []byte, match []int) []byte {
+		return append(dst, repl...)
+	})
+}
+
+// ReplaceAllFunc returns a copy of src with every match replaced by the
+// result of calling repl on the matched text. The result is used as-is
+// without expanding $ references.
+func (re *Regex) ReplaceAllFunc(src []byte, repl func([]byte) []byte) []byte {
+	return re.replaceAll(src, 2, func(dst []byte, match []int) []byte {
+		return append(dst, repl(src[match[0]:match[1]])...)
+	})
+}
+
+// replaceAll copies src to a new slice, letting repl append the
+// replacement for each match in place of the matched text.
+func (re *Regex) replaceAll(src []byte, nslots int, repl func(dst []byte, match []int) []byte) []byte {
+	var out []byte
+	last := 0
+	re.allMatches(src, -1, nslots, func(match []int) {
+		out = append(out, src[last:match[0]]...)
+		out = repl(out, match)
+		last = match[1]
+	})
+	return append(out, src[last:]...)
+}
+
+// Expand appends template to dst with $ references replaced by groups of
+// the match in src, and returns the result. match holds capture offsets
+// as returned by FindSubmatchIndex. The template syntax is described at
+// ReplaceAll.
+func (re *Regex) Expand(dst, template, src []byte, match []int) []byte {
+	for len(template) > 0 {
+		i := bytes.IndexByte(template, '$')
+		if i < 0 {
+			break
+		}
+		dst = append(dst, template[:i]...)
+		template = template[i:]
+		if len(template) > 1 && template[1] == '$' {
+			dst = append(dst, '$')
+			template = template[2:]
+			continue
+		}
+		name, rest, ok := extractRef(template)
+		if !ok {
+			// Malformed reference: keep the '$' as plain text.
+			dst = append(dst, '$')
+			template = template[1:]
+			continue
+		}
+		template = rest
+		if n := re.groupIndex(name); n >= 0 && 2*n+1 < len(match) && match[2*n] >= 0 {
+			dst = append(dst, src[match[2*n]:match[2*n+1]]...)
+		}
+	}
+	return append(dst, template...)
+}
+
+// extractRef parses the $name or ${name} reference at the start of
+// template and returns the name and the text after it.
+func extractRef(template []byte) (name string, rest []byte, ok bool) {
+	if len(template) < 2 || template[0] != '$' {
+		return "", nil, false
+	}
+	brace := template[1] == '{'
+	i := 1
+	if brace {
+		i++
+	}
+	start := i
+	for i < len(template) && isNameByte(template[i]) {
+		i++
+	}
+	if i == start {
+		return "", nil, false
+	}
+	name = string(template[start:i])
+	if brace {
+		if i >= len(template) || template[i] != '}' {
+			return "", nil, false
+		}
+		i++
+	}
+	return name, template[i:], true
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// groupIndex returns the number of the group a template reference names,
+// either by number or by group name, or -1 if there is no such group.
+func (re *Regex) groupIndex(name string) int {
+	n := 0
+	for i := 0; i < len(name); i++ {
+		if name[i] < '0' || name[i] > '9' {
+			n = -1
+			break
+		}
+		if n = n*10 + int(name[i]-'0'); n > re.ncap {
+			return -1
+		}
+	}
+	if n >= 0 {
+		return n
+	}
+	for i, g := range re.names {
+		if g != "" && g == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -3,67 +3,568 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rafaelmgr12/mygrep/regex"
+)
+
+// syntaxMode selects how patterns are interpreted.
+type syntaxMode int
+
+const (
+	modeBasic    syntaxMode = iota // -G: POSIX basic regular expressions
+	modeExtended                   // -E: extended regular expressions
+	modeFixed                      // -F: literal strings
 )
 
 // Args holds parsed command-line arguments.
 type Args struct {
-	Recursive bool
-	Pattern   string
-	Paths     []string
+	Recursive         bool
+	IgnoreCase        bool
+	Mode              syntaxMode
+	PatternFiles      []string // -f: files with one pattern per line
+	Replace           *string  // --replace template, nil if not given
+	Invert            bool     // -v: select non-matching lines
+	Count             bool     // -c: print the number of selected lines
+	FilesWithMatches  bool     // -l: print the names of files with a selected line
+	FilesWithoutMatch bool     // -L: print the names of files without one
+	Quiet             bool     // -q: print nothing, stop at the first selected line
+	OnlyMatching      bool     // -o: print each match instead of the whole line
+	MaxCount          int      // -m: stop reading a file after this many selected lines; -1 for no limit
+	LineNumber        bool     // -n: prefix lines with their line number
+	ByteOffset        bool     // -b: prefix lines with their byte offset
+	Column            bool     // --column: prefix lines with the column of the first match
+	WithFilename      bool     // -H: always prefix lines with the file name
+	NoFilename        bool     // -h: never prefix lines with the file name
+	Color             string   // --color: auto, always or never
+	After             int      // -A: context lines to print after a selected line; -1 if not given
+	Before            int      // -B: context lines to print before one; -1 if not given
+	Context           int      // -C: the default for both; -1 if not given
+	Pattern           string   // unused if PatternFiles is set
+	Paths             []string
 }
 
+const usage = `usage: mygrep [-E | -F | -G] [-r] [-i] [-v] [-o] [-c | -l | -L | -q] [-m num]
+              [-n] [-b] [--column] [-H | -h] [-A num] [-B num] [-C num]
+              [--color[=when]] [--replace <template>]
+              (<pattern> | -f <file>) [path ...]
+`
+
+// flagLetters are the short options that take no value, which can be
+// bundled as in -rn.
+const flagLetters = "riEFGvclLqonbHh"
+
 // parseArgs parses command-line arguments and returns an Args struct.
+// Short options can be bundled, so -rn is -r -n and -nA2 is -n -A2, and
+// "--" ends the options so that a pattern can start with '-'.
 func parseArgs() Args {
-	var recursive bool
+	args := Args{MaxCount: -1, After: -1, Before: -1, Context: -1, Color: "auto"}
+	argv := append([]string(nil), os.Args...)
 	i := 1
-	if len(os.Args) > i && os.Args[i] == "-r" {
-		recursive = true
+options:
+	for ; i < len(argv); i++ {
+		arg := argv[i]
+		if arg == "--" {
+			i++
+			break
+		}
+		if len(arg) > 2 && arg[0] == '-' && arg[1] != '-' && strings.IndexByte(flagLetters, arg[1]) >= 0 {
+			// Take the first letter of a bundle and leave the rest as
+			// the next argument.
+			argv = slices.Insert(argv, i+1, "-"+arg[2:])
+			arg = arg[:2]
+		}
+		// value returns the argument of an option given as "-x value",
+		// "-xvalue" or "--name=value", or reports whether none is
+		// available. An option with an empty short name only takes the
+		// "--name=value" form.
+		value := func(short, long string) (string, bool) {
+			if short != "" && arg == short && i+1 < len(argv) {
+				i++
+				return argv[i], true
+			}
+			if len(short) == 2 && len(arg) > 2 && strings.HasPrefix(arg, short) {
+				return arg[2:], true
+			}
+			if strings.HasPrefix(arg, long+"=") {
+				return strings.TrimPrefix(arg, long+"="), true
+			}
+			return "", false
+		}
+		switch arg {
+		case "-r":
+			args.Recursive = true
+		case "-i":
+			args.IgnoreCase = true
+		case "-E", "--extended-regexp":
+			args.Mode = modeExtended
+		case "-F", "--fixed-strings":
+			args.Mode = modeFixed
+		case "-G", "--basic-regexp":
+			args.Mode = modeBasic
+		case "-v", "--invert-match":
+			args.Invert = true
+		case "-c", "--count":
+			args.Count = true
+		case "-l", "--files-with-matches":
+			args.FilesWithMatches = true
+		case "-L", "--files-without-match":
+			args.FilesWithoutMatch = true
+		case "-q", "--quiet", "--silent":
+			args.Quiet = true
+		case "-o", "--only-matching":
+			args.OnlyMatching = true
+		case "-n", "--line-number":
+			args.LineNumber = true
+		case "-b", "--byte-offset":
+			args.ByteOffset = true
+		case "--column":
+			args.Column = true
+		case "--color", "--colour":
+			args.Color = "auto"
+		case "-H", "--with-filename":
+			args.WithFilename, args.NoFilename = true, false
+		case "-h", "--no-filename":
+			args.WithFilename, args.NoFilename = false, true
+		default:
+			if v, ok := value("-f", "--file"); ok {
+				args.PatternFiles = append(args.PatternFiles, v)
+			} else if v, ok := value("--replace", "--replace"); ok {
+				args.Replace = &v
+			} else if v, ok := value("-m", "--max-count"); ok {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 {
+					fmt.Fprintf(os.Stderr, "error: invalid max count %q\n", v)
+					os.Exit(2)
+				}
+				args.MaxCount = n
+			} else if v, ok := value("", "--color"); ok {
+				args.Color = parseColorMode(v)
+			} else if v, ok := value("", "--colour"); ok {
+				args.Color = parseColorMode(v)
+			} else if v, ok := value("-A", "--after-context"); ok {
+				args.After = contextLength(v)
+			} else if v, ok := value("-B", "--before-context"); ok {
+				args.Before = contextLength(v)
+			} else if v, ok := value("-C", "--context"); ok {
+				args.Context = contextLength(v)
+			} else {
+				break options
+			}
+		}
+	}
+	if len(args.PatternFiles) == 0 {
+		if len(argv) <= i {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		args.Pattern = argv[i]
 		i++
 	}
-	if len(os.Args) <= i || os.Args[i] != "-E" {
-		fmt.Fprintf(os.Stderr, "usage: mygrep [-r] -E <pattern> [path ...]\n")
+	args.Paths = argv[i:]
+	return args
+}
+
+// contextLength parses the value of -A, -B or -C, exiting on an invalid
+// one.
+func contextLength(v string) int {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		fmt.Fprintf(os.Stderr, "error: invalid context length %q\n", v)
 		os.Exit(2)
 	}
-	i++
-	if len(os.Args) <= i {
-		fmt.Fprintf(os.Stderr, "error: missing pattern\n")
-		os.Exit(2)
+	return n
+}
+
+// loadPatterns returns the patterns to search for: the lines of the -f
+// files if any were given, otherwise the lines of the pattern argument.
+func loadPatterns(args Args) ([]string, error) {
+	if len(args.PatternFiles) == 0 {
+		return strings.Split(args.Pattern, "\n"), nil
+	}
+	var patterns []string
+	for _, name := range args.PatternFiles {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		patterns = append(patterns, strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")...)
 	}
-	pattern := os.Args[i]
-	i++
-	paths := os.Args[i:]
-	return Args{Recursive: recursive, Pattern: pattern, Paths: paths}
+	return patterns, nil
 }
 
-// grepStdin reads from standard input and prints matching lines.
-func grepStdin(re *Regex) bool {
-	found := false
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		line := scanner.Text()
-		ok, matchErr := re.Match([]byte(line))
-		if matchErr != nil {
-			fmt.Fprintf(os.Stderr, "error: %v\n", matchErr)
-			os.Exit(2)
+// compilePatterns compiles patterns, in the syntax of args.Mode, into one
+// expression that matches where any of them does. Each pattern is parsed
+// on its own, so errors point into the line the user wrote and
+// backreferences stay within their pattern. Fixed strings are quoted as
+// extended syntax, which lets the whole list be matched with a single
+// Aho-Corasick pass. An empty list matches nothing.
+func compilePatterns(patterns []string, args Args) (*regex.Regex, error) {
+	if args.Mode == modeFixed {
+		quoted := make([]string, len(patterns))
+		for i, p := range patterns {
+			quoted[i] = regex.QuoteMeta(p)
 		}
-		if ok {
-			fmt.Println(line)
-			found = true
+		patterns = quoted
+	}
+	return regex.CompileList(patterns, regex.Options{
+		BRE:        args.Mode == modeBasic,
+		IgnoreCase: args.IgnoreCase,
+		Longest:    true,
+	})
+}
+
+// outputMode selects what a search prints.
+type outputMode int
+
+const (
+	outputLines        outputMode = iota // the selected lines
+	outputCount                          // -c: a count of selected lines per input
+	outputFilesWith                      // -l: names of inputs with a selected line
+	outputFilesWithout                   // -L: names of inputs without one
+	outputQuiet                          // -q: nothing
+)
+
+// searcher holds the compiled pattern and output settings for a search.
+type searcher struct {
+	re        *regex.Regex
+	replace   []byte // --replace template, applied if replacing is set
+	replacing bool
+	invert    bool // select lines that do not match
+	only      bool // print each match rather than the whole line
+	output    outputMode
+	maxCount  int  // stop reading an input after this many selected lines; 0 for no limit
+	lineNum   bool // prefix lines with their line number
+	offset    bool // prefix lines with their byte offset
+	column    bool // prefix lines with the column of the first match
+	before    int  // context lines to print before a selected line
+	after     int  // context lines to print after one
+	printed   bool // a line has been printed, so the next group needs a separator
+	color     bool // highlight output with colors
+	colors    palette
+}
+
+// newSearcher returns a searcher for re with the output settings in args.
+func newSearcher(re *regex.Regex, args Args) *searcher {
+	s := &searcher{
+		re:       re,
+		invert:   args.Invert,
+		only:     args.OnlyMatching,
+		maxCount: max(args.MaxCount, 0),
+		lineNum:  args.LineNumber,
+		offset:   args.ByteOffset,
+		column:   args.Column,
+		before:   contextArg(args.Before, args.Context),
+		after:    contextArg(args.After, args.Context),
+	}
+	if args.Replace != nil {
+		s.replace = []byte(*args.Replace)
+		s.replacing = true
+	}
+	switch {
+	case args.Quiet:
+		s.output = outputQuiet
+	case args.FilesWithMatches:
+		s.output = outputFilesWith
+	case args.FilesWithoutMatch:
+		s.output = outputFilesWithout
+	case args.Count:
+		s.output = outputCount
+	}
+	if colorEnabled(args.Color) {
+		s.color = true
+		s.colors = parseGrepColors(os.Getenv("GREP_COLORS"))
+	}
+	if s.only {
+		// Matches are printed without their surrounding lines.
+		s.before, s.after = 0, 0
+	}
+	return s
+}
+
+// contextArg returns the context length given by -A or -B, falling back
+// to the one given by -C.
+func contextArg(n, context int) int {
+	if n >= 0 {
+		return n
+	}
+	return max(context, 0)
+}
+
+// stopAll reports whether the whole search can end now that found is the
+// result so far: in quiet mode the first selected line decides the exit
+// status.
+func (s *searcher) stopAll(found bool) bool {
+	return found && s.output == outputQuiet
+}
+
+// scan is the search pipeline shared by every input. It reads r line by
+// line, selects the lines that match (or, with -v, do not), and prints the
+// result for the input named name. It reports whether any line was
+// selected. Reading stops early once the selected lines reach the -m limit
+// or, when only the existence of a selected line matters, at the first one.
+// With -A, -B or -C the selected lines are printed among their neighbours,
+// and groups that are not adjacent are separated by a "--" line.
+func (s *searcher) scan(r io.Reader, name string, multiPrefix bool) (bool, error) {
+	if name == "" {
+		name = "(standard input)"
+	}
+	limit := s.maxCount
+	if s.output != outputLines && s.output != outputCount {
+		limit = 1
+	}
+	count := 0
+	scanner := bufio.NewScanner(r)
+	// The scanner strips line terminators, so remember how many bytes each
+	// line really took to keep -b offsets exact.
+	advance := 0
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		n, token, err := bufio.ScanLines(data, atEOF)
+		if token != nil {
+			advance = n
+		}
+		return n, token, err
+	})
+	var ring *lineRing
+	if s.output == outputLines && s.before > 0 {
+		ring = newLineRing(s.before)
+	}
+	last := 0      // number of the last line printed from r, 0 if none
+	afterLeft := 0 // context lines still to print after the last selected one
+	emit := func(num, col, offset int, line []byte, selected bool) {
+		if (s.before > 0 || s.after > 0) && s.printed && (last == 0 || num > last+1) {
+			fmt.Println(s.colors.paint(s.colors.se, "--"))
+		}
+		s.printed, last = true, num
+		sep := byte('-')
+		if selected {
+			sep = ':'
+		}
+		fmt.Printf("%s%s\n", s.prefix(name, num, col, offset, sep, multiPrefix), s.render(line, selected))
+	}
+
+	num, offset := 0, 0
+	for (limit == 0 || count < limit || afterLeft > 0) && scanner.Scan() {
+		line := scanner.Bytes()
+		num++
+		start := offset
+		offset += advance
+		if limit > 0 && count >= limit {
+			// Past the -m limit only the trailing context is left.
+			emit(num, 0, start, line, false)
+			afterLeft--
+			continue
+		}
+
+		// The match position is only needed for --column; otherwise the
+		// cheaper Match is enough.
+		var loc []int
+		var ok bool
+		if s.column {
+			loc = s.re.FindIndex(line)
+			ok = loc != nil
+		} else {
+			var err error
+			if ok, err = s.re.Match(line); err != nil {
+				return count > 0, err
+			}
+		}
+		if ok == s.invert {
+			if afterLeft > 0 {
+				emit(num, 0, start, line, false)
+				afterLeft--
+			} else if ring != nil {
+				ring.push(num, start, line)
+			}
+			continue
+		}
+		count++
+		if s.output == outputLines {
+			if ring != nil {
+				ring.drain(func(l ringLine) { emit(l.num, 0, l.offset, l.text, false) })
+			}
+			if s.only {
+				s.printOnly(name, num, start, line, multiPrefix)
+				continue
+			}
+			col := 0
+			if loc != nil {
+				col = loc[0] + 1
+			}
+			emit(num, col, start, line, true)
+			afterLeft = s.after
 		}
 	}
 	if err := scanner.Err(); err != nil {
+		return count > 0, err
+	}
+	s.report(name, count, multiPrefix)
+	return count > 0, nil
+}
+
+// lineRing holds the last few unselected lines read, the candidates for
+// -B context.
+type lineRing struct {
+	lines []ringLine
+	next  int // slot the next line goes in
+	n     int // number of lines held
+}
+
+// ringLine is a line held in a lineRing with the position it was read at.
+type ringLine struct {
+	num, offset int
+	text        []byte
+}
+
+func newLineRing(size int) *lineRing {
+	return &lineRing{lines: make([]ringLine, size)}
+}
+
+// push adds a line, dropping the oldest one if the ring is full. The text
+// is copied, since the scanner reuses its buffer.
+func (r *lineRing) push(num, offset int, text []byte) {
+	l := &r.lines[r.next]
+	l.num, l.offset, l.text = num, offset, append(l.text[:0], text...)
+	r.next = (r.next + 1) % len(r.lines)
+	r.n = min(r.n+1, len(r.lines))
+}
+
+// drain calls f on the lines held, oldest first, and empties the ring.
+func (r *lineRing) drain(f func(ringLine)) {
+	for i := r.n; i > 0; i-- {
+		f(r.lines[(r.next-i+len(r.lines))%len(r.lines)])
+	}
+	r.n = 0
+}
+
+// report prints the per-input summary of the -c, -l and -L modes.
+func (s *searcher) report(name string, count int, multiPrefix bool) {
+	switch s.output {
+	case outputCount:
+		if multiPrefix {
+			fmt.Printf("%s%s%d\n", s.colors.paint(s.colors.fn, name), s.colors.paint(s.colors.se, ":"), count)
+		} else {
+			fmt.Println(count)
+		}
+	case outputFilesWith:
+		if count > 0 {
+			fmt.Println(s.colors.paint(s.colors.fn, name))
+		}
+	case outputFilesWithout:
+		if count == 0 {
+			fmt.Println(s.colors.paint(s.colors.fn, name))
+		}
+	}
+}
+
+// prefix returns the fields printed before an output line, each followed
+// by sep: the input name if multiPrefix is set, then the line number, the
+// 1-based byte column of the first match and the byte offset of the line,
+// as the -n, --column and -b options ask. A column of 0 means there is no
+// match to report, as on lines selected by -v, and is left out.
+func (s *searcher) prefix(name string, num, col, offset int, sep byte, multiPrefix bool) string {
+	var b strings.Builder
+	field := func(code, text string) {
+		b.WriteString(s.colors.paint(code, text))
+		b.WriteString(s.colors.paint(s.colors.se, string(sep)))
+	}
+	if multiPrefix {
+		field(s.colors.fn, name)
+	}
+	if s.lineNum {
+		field(s.colors.ln, strconv.Itoa(num))
+	}
+	if s.column && col > 0 {
+		field(s.colors.ln, strconv.Itoa(col))
+	}
+	if s.offset {
+		field(s.colors.bn, strconv.Itoa(offset))
+	}
+	return b.String()
+}
+
+// render returns line as it is printed: on a selected line, matches are
+// replaced in --replace mode, and with color on, the matches of any line
+// that has them are highlighted. Lines that have them are the selected
+// ones, or the context lines when -v inverts the selection.
+func (s *searcher) render(line []byte, selected bool) []byte {
+	replace := selected && s.replacing
+	lineColor, matchColor := s.colors.cx, s.colors.mc
+	if selected {
+		lineColor, matchColor = s.colors.sl, s.colors.ms
+	}
+	if !s.color || selected == s.invert {
+		if replace {
+			line = s.re.ReplaceAll(line, s.replace)
+		}
+		return []byte(s.colors.paint(lineColor, string(line)))
+	}
+
+	var locs [][]int
+	if replace {
+		locs = s.re.FindAllSubmatchIndex(line, -1)
+	} else {
+		locs = s.re.FindAllIndex(line, -1)
+	}
+	var out []byte
+	prev := 0
+	for _, loc := range locs {
+		match := line[loc[0]:loc[1]]
+		if replace {
+			match = s.re.Expand(nil, s.replace, line, loc)
+		}
+		out = append(out, s.colors.paint(lineColor, string(line[prev:loc[0]]))...)
+		out = append(out, s.colors.paint(matchColor, string(match))...)
+		prev = loc[1]
+	}
+	return append(out, s.colors.paint(lineColor, string(line[prev:]))...)
+}
+
+// printOnly prints every non-overlapping match in line on its own output
+// line, for -o, expanding the --replace template for each one if given.
+// Empty matches print nothing. The --column and -b prefixes give the
+// position of each match rather than of the line, which starts at offset.
+func (s *searcher) printOnly(name string, num, offset int, line []byte, multiPrefix bool) {
+	var locs [][]int
+	if s.replacing {
+		locs = s.re.FindAllSubmatchIndex(line, -1)
+	} else {
+		locs = s.re.FindAllIndex(line, -1)
+	}
+	for _, loc := range locs {
+		if loc[0] == loc[1] {
+			continue
+		}
+		text := line[loc[0]:loc[1]]
+		if s.replacing {
+			text = s.re.Expand(nil, s.replace, line, loc)
+		}
+		fmt.Printf("%s%s\n", s.prefix(name, num, loc[0]+1, offset+loc[0], ':', multiPrefix), s.colors.paint(s.colors.ms, string(text)))
+	}
+}
+
+// grepStdin searches standard input, naming it in output only if
+// multiPrefix is set.
+func (s *searcher) grepStdin(multiPrefix bool) bool {
+	found, err := s.scan(os.Stdin, "", multiPrefix)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: read input: %v\n", err)
 		os.Exit(2)
 	}
 	return found
 }
 
-// grepFile searches for matches in a single file.
-func grepFile(re *Regex, path string, multiPrefix bool) bool {
-	found := false
+// grepFile searches a single file.
+func (s *searcher) grepFile(path string, multiPrefix bool) bool {
 	fi, serr := os.Stat(path)
 	if serr != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", serr)
@@ -78,33 +579,17 @@ func grepFile(re *Regex, path string, multiPrefix bool) bool {
 		fmt.Fprintf(os.Stderr, "error: open %s: %v\n", path, oerr)
 		os.Exit(2)
 	}
-	scanner := bufio.NewScanner(reader)
-	for scanner.Scan() {
-		line := scanner.Text()
-		ok, merr := re.Match([]byte(line))
-		if merr != nil {
-			fmt.Fprintf(os.Stderr, "error: %v\n", merr)
-			os.Exit(2)
-		}
-		if ok {
-			if multiPrefix {
-				fmt.Printf("%s:%s\n", path, line)
-			} else {
-				fmt.Println(line)
-			}
-			found = true
-		}
-	}
-	if serr := scanner.Err(); serr != nil {
-		fmt.Fprintf(os.Stderr, "error: read %s: %v\n", path, serr)
+	defer reader.Close()
+	found, err := s.scan(reader, path, multiPrefix)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: read %s: %v\n", path, err)
 		os.Exit(2)
 	}
-	reader.Close()
 	return found
 }
 
-// grepRecursive searches for matches recursively in directories.
-func grepRecursive(re *Regex, root string, multiPrefix bool) bool {
+// grepRecursive searches every file under root.
+func (s *searcher) grepRecursive(root string, multiPrefix bool) bool {
 	found := false
 	walkErr := filepath.WalkDir(root, func(fpath string, d fs.DirEntry, err error) error {
 		if err != nil {
@@ -119,28 +604,18 @@ func grepRecursive(re *Regex, root string, multiPrefix bool) bool {
 			fmt.Fprintf(os.Stderr, "error: open %s: %v\n", fpath, oerr)
 			return oerr
 		}
-		scanner := bufio.NewScanner(reader)
-		for scanner.Scan() {
-			line := scanner.Text()
-			ok, merr := re.Match([]byte(line))
-			if merr != nil {
-				fmt.Fprintf(os.Stderr, "error: %v\n", merr)
-				os.Exit(2)
-			}
-			if ok {
-				if multiPrefix {
-					fmt.Printf("%s:%s\n", fpath, line)
-				} else {
-					fmt.Println(line)
-				}
-				found = true
-			}
-		}
-		if serr := scanner.Err(); serr != nil {
+		defer reader.Close()
+		ok, serr := s.scan(reader, fpath, multiPrefix)
+		if serr != nil {
 			fmt.Fprintf(os.Stderr, "error: read %s: %v\n", fpath, serr)
 			return serr
 		}
-		reader.Close()
+		if ok {
+			found = true
+		}
+		if s.stopAll(found) {
+			return fs.SkipAll
+		}
 		return nil
 	})
 	if walkErr != nil {
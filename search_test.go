@@ -2,12 +2,18 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/rafaelmgr12/mygrep/regex"
 )
 
 func TestParseArgs(t *testing.T) {
@@ -15,9 +21,181 @@ func TestParseArgs(t *testing.T) {
 	defer func() { os.Args = orig }()
 	os.Args = []string{"mygrep", "-r", "-E", "pattern", "file1", "file2"}
 	args := parseArgs()
-	if !args.Recursive || args.Pattern != "pattern" || len(args.Paths) != 2 || args.Paths[0] != "file1" || args.Paths[1] != "file2" {
+	if !args.Recursive || args.Mode != modeExtended || args.Pattern != "pattern" || len(args.Paths) != 2 || args.Paths[0] != "file1" || args.Paths[1] != "file2" {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+	os.Args = []string{"mygrep", "-i", "-r", "-E", "pattern"}
+	args = parseArgs()
+	if !args.IgnoreCase || !args.Recursive || args.Pattern != "pattern" || len(args.Paths) != 0 {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+	os.Args = []string{"mygrep", "--replace", "$1", "-E", "(a)"}
+	args = parseArgs()
+	if args.Replace == nil || *args.Replace != "$1" || args.Pattern != "(a)" {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+	os.Args = []string{"mygrep", "-F", "-i", "a.b", "file1"}
+	args = parseArgs()
+	if args.Mode != modeFixed || !args.IgnoreCase || args.Pattern != "a.b" || len(args.Paths) != 1 {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+	os.Args = []string{"mygrep", "--fixed-strings", "-f", "a.txt", "--file=b.txt", "file1", "file2"}
+	args = parseArgs()
+	if args.Mode != modeFixed || !reflect.DeepEqual(args.PatternFiles, []string{"a.txt", "b.txt"}) || len(args.Paths) != 2 {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+	os.Args = []string{"mygrep", "-E", "-G", "a\\+", "file1"}
+	args = parseArgs()
+	if args.Mode != modeBasic || args.Pattern != `a\+` || len(args.Paths) != 1 {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+	os.Args = []string{"mygrep", "-v", "-c", "-l", "-L", "-q", "-m", "3", "pattern"}
+	args = parseArgs()
+	if !args.Invert || !args.Count || !args.FilesWithMatches || !args.FilesWithoutMatch || !args.Quiet || args.MaxCount != 3 {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+	os.Args = []string{"mygrep", "--max-count=0", "--invert-match", "-fpats.txt"}
+	args = parseArgs()
+	if args.MaxCount != 0 || !args.Invert || len(args.PatternFiles) != 1 || args.PatternFiles[0] != "pats.txt" {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+	os.Args = []string{"mygrep", "-n", "-b", "--column", "-h", "-H", "pattern"}
+	args = parseArgs()
+	if !args.LineNumber || !args.ByteOffset || !args.Column || !args.WithFilename || args.NoFilename {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+	os.Args = []string{"mygrep", "--with-filename", "--no-filename", "pattern"}
+	args = parseArgs()
+	if args.WithFilename || !args.NoFilename {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+	os.Args = []string{"mygrep", "-o", "--only-matching", "pattern"}
+	args = parseArgs()
+	if !args.OnlyMatching || args.Pattern != "pattern" {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+	os.Args = []string{"mygrep", "--color=always", "pattern"}
+	args = parseArgs()
+	if args.Color != "always" || args.Pattern != "pattern" {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+	os.Args = []string{"mygrep", "--colour=never", "--color", "pattern"}
+	args = parseArgs()
+	if args.Color != "auto" || args.Pattern != "pattern" {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+	os.Args = []string{"mygrep", "-E", "", "a.txt"}
+	args = parseArgs()
+	if args.Pattern != "" || args.Color != "auto" || !reflect.DeepEqual(args.Paths, []string{"a.txt"}) {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+	os.Args = []string{"mygrep", "-A2", "-B", "1", "--context=3", "pattern"}
+	args = parseArgs()
+	if args.After != 2 || args.Before != 1 || args.Context != 3 {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+	os.Args = []string{"mygrep", "-i", "pattern"}
+	args = parseArgs()
+	if args.Mode != modeBasic || !args.IgnoreCase || args.MaxCount != -1 || args.Context != -1 || args.Color != "auto" || args.Pattern != "pattern" || len(args.Paths) != 0 {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+	os.Args = []string{"mygrep", "-rn", "foo", "."}
+	args = parseArgs()
+	if !args.Recursive || !args.LineNumber || args.Pattern != "foo" || !reflect.DeepEqual(args.Paths, []string{"."}) {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+	os.Args = []string{"mygrep", "-cE", "a|b", "f"}
+	args = parseArgs()
+	if !args.Count || args.Mode != modeExtended || args.Pattern != "a|b" || !reflect.DeepEqual(args.Paths, []string{"f"}) {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+	os.Args = []string{"mygrep", "-inA2", "-vf", "pats.txt", "f"}
+	args = parseArgs()
+	if !args.IgnoreCase || !args.LineNumber || args.After != 2 || !args.Invert || !reflect.DeepEqual(args.PatternFiles, []string{"pats.txt"}) || !reflect.DeepEqual(args.Paths, []string{"f"}) {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+	os.Args = []string{"mygrep", "-n", "--", "-pat", "-f"}
+	args = parseArgs()
+	if !args.LineNumber || args.Pattern != "-pat" || len(args.PatternFiles) != 0 || !reflect.DeepEqual(args.Paths, []string{"-f"}) {
 		t.Fatalf("unexpected args: %#v", args)
 	}
+	if !reflect.DeepEqual(os.Args, []string{"mygrep", "-n", "--", "-pat", "-f"}) {
+		t.Fatalf("parseArgs changed os.Args to %q", os.Args)
+	}
+}
+
+func TestLoadAndCompilePatterns(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "pats.txt")
+	os.WriteFile(file, []byte("tok.1\n[x]\n"), 0644)
+	empty := filepath.Join(dir, "empty.txt")
+	os.WriteFile(empty, nil, 0644)
+
+	tests := []struct {
+		args     Args
+		match    []string
+		nomatch  []string
+		wantList string
+	}{
+		{Args{Pattern: "a|b", Mode: modeExtended}, []string{"b"}, []string{"c"}, "a|b"},
+		{Args{Pattern: "a\nb+", Mode: modeExtended}, []string{"a", "bb"}, []string{"c"}, "a\nb+"},
+		{Args{Pattern: "(?i)a\nb", Mode: modeExtended}, []string{"A", "b"}, []string{"B"}, "(?i)a\nb"},
+		{Args{Pattern: "a\nb\\+"}, []string{"a", "bb"}, []string{"c"}, "a\nb\\+"},
+		{Args{Pattern: "(a)\\1\n(b)\\1", Mode: modeExtended}, []string{"aa", "bb"}, []string{"ab"}, "(a)\\1\n(b)\\1"},
+		{Args{Pattern: `\(a\)\1` + "\n" + `\(b\)\1`}, []string{"aa", "bb"}, []string{"ab"}, `\(a\)\1` + "\n" + `\(b\)\1`},
+		{Args{Pattern: "a.b", Mode: modeFixed}, []string{"a.b"}, []string{"axb"}, `a\.b`},
+		{Args{PatternFiles: []string{file}, Mode: modeFixed}, []string{"tok.1", "[x]"}, []string{"tokx1", "x"}, "tok\\.1\n\\[x\\]"},
+		{Args{PatternFiles: []string{empty}}, nil, []string{"", "a"}, ""},
+	}
+	for _, tt := range tests {
+		patterns, err := loadPatterns(tt.args)
+		if err != nil {
+			t.Fatalf("loadPatterns(%#v) error: %v", tt.args, err)
+		}
+		re, err := compilePatterns(patterns, tt.args)
+		if err != nil {
+			t.Fatalf("compilePatterns(%q) error: %v", patterns, err)
+		}
+		if re.String() != tt.wantList {
+			t.Errorf("compilePatterns(%q).String() = %q, want %q", patterns, re.String(), tt.wantList)
+		}
+		for _, text := range tt.match {
+			if ok, _ := re.Match([]byte(text)); !ok {
+				t.Errorf("compilePatterns(%q) does not match %q", patterns, text)
+			}
+		}
+		for _, text := range tt.nomatch {
+			if ok, _ := re.Match([]byte(text)); ok {
+				t.Errorf("compilePatterns(%q) matches %q", patterns, text)
+			}
+		}
+	}
+	if _, err := loadPatterns(Args{PatternFiles: []string{filepath.Join(dir, "missing")}}); err == nil {
+		t.Errorf("loadPatterns of a missing file: expected error")
+	}
+}
+
+func TestCompilePatterns_ErrorNamesPattern(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		mode     syntaxMode
+		pattern  string
+		offset   int
+		msg      string
+	}{
+		{[]string{"ok", "(bad"}, modeExtended, "(bad", 0, "unterminated group"},
+		// Joined with \| the trailing backslash would have quoted the
+		// separator and searched for the literal "a\|b".
+		{[]string{`a\`, "b"}, modeBasic, `a\`, 1, "trailing backslash at end of pattern"},
+		{[]string{"a", `\(b`}, modeBasic, `\(b`, 0, "unterminated group"},
+	}
+	for _, tt := range tests {
+		_, err := compilePatterns(tt.patterns, Args{Mode: tt.mode})
+		var serr *regex.SyntaxError
+		if !errors.As(err, &serr) || serr.Pattern != tt.pattern || serr.Offset != tt.offset || serr.Msg != tt.msg {
+			t.Errorf("compilePatterns(%q) error = %v, want %q at offset %d of %q", tt.patterns, err, tt.msg, tt.offset, tt.pattern)
+		}
+	}
 }
 
 func captureOutput(f func()) string {
@@ -34,7 +212,8 @@ func captureOutput(f func()) string {
 }
 
 func TestGrepStdin(t *testing.T) {
-	re, _ := Compile("foo")
+	re, _ := regex.Compile("foo")
+	s := &searcher{re: re}
 	inR, inW, _ := os.Pipe()
 	oldIn := os.Stdin
 	os.Stdin = inR
@@ -43,7 +222,7 @@ func TestGrepStdin(t *testing.T) {
 		inW.WriteString("bar\nfoo\n")
 	}()
 	out := captureOutput(func() {
-		if !grepStdin(re) {
+		if !s.grepStdin(false) {
 			t.Fatalf("expected match")
 		}
 	})
@@ -57,9 +236,10 @@ func TestGrepFile(t *testing.T) {
 	dir := t.TempDir()
 	file := filepath.Join(dir, "test.txt")
 	os.WriteFile(file, []byte("hello\nfoo\nbar\n"), 0644)
-	re, _ := Compile("foo")
+	re, _ := regex.Compile("foo")
+	s := &searcher{re: re}
 	out := captureOutput(func() {
-		if !grepFile(re, file, false) {
+		if !s.grepFile(file, false) {
 			t.Fatalf("expected match")
 		}
 	})
@@ -67,7 +247,7 @@ func TestGrepFile(t *testing.T) {
 		t.Fatalf("unexpected output %q", out)
 	}
 	out2 := captureOutput(func() {
-		if !grepFile(re, file, true) {
+		if !s.grepFile(file, true) {
 			t.Fatalf("expected match")
 		}
 	})
@@ -85,9 +265,10 @@ func TestGrepRecursive(t *testing.T) {
 	os.Mkdir(sub, 0755)
 	f2 := filepath.Join(sub, "f2.txt")
 	os.WriteFile(f2, []byte("bar\nfoo\n"), 0644)
-	re, _ := Compile("foo")
+	re, _ := regex.Compile("foo")
+	s := &searcher{re: re}
 	out := captureOutput(func() {
-		if !grepRecursive(re, root, true) {
+		if !s.grepRecursive(root, true) {
 			t.Fatalf("expected match")
 		}
 	})
@@ -102,3 +283,311 @@ func TestGrepRecursive(t *testing.T) {
 		t.Fatalf("unexpected lines %v", lines)
 	}
 }
+
+func TestGrepFileReplace(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "test.txt")
+	os.WriteFile(file, []byte("name=alice\nskip\nname=bob id=7\n"), 0644)
+	re, _ := regex.Compile("(\\w+)=(\\w+)")
+	s := &searcher{re: re, replace: []byte("$2:$1"), replacing: true}
+	out := captureOutput(func() {
+		if !s.grepFile(file, false) {
+			t.Fatalf("expected match")
+		}
+	})
+	if out != "alice:name\nbob:name 7:id\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestSearcherScanModes(t *testing.T) {
+	input := "foo 1\nbar\nfoo 2\nbaz\nfoo 3\n"
+	tests := []struct {
+		name  string
+		args  Args
+		multi bool
+		want  string
+		found bool
+	}{
+		{"lines", Args{}, false, "foo 1\nfoo 2\nfoo 3\n", true},
+		{"invert", Args{Invert: true}, false, "bar\nbaz\n", true},
+		{"count", Args{Count: true}, false, "3\n", true},
+		{"count with name", Args{Count: true}, true, "in.txt:3\n", true},
+		{"count inverted", Args{Count: true, Invert: true}, true, "in.txt:2\n", true},
+		{"max count", Args{MaxCount: 2}, false, "foo 1\nfoo 2\n", true},
+		{"max count with count", Args{Count: true, MaxCount: 2}, false, "2\n", true},
+		{"files with matches", Args{FilesWithMatches: true}, false, "in.txt\n", true},
+		{"files without match", Args{FilesWithoutMatch: true}, false, "", true},
+		{"quiet", Args{Quiet: true}, true, "", true},
+		{"quiet wins over count", Args{Quiet: true, Count: true}, false, "", true},
+	}
+	re := regex.MustCompile("foo")
+	for _, tt := range tests {
+		s := newSearcher(re, tt.args)
+		var found bool
+		out := captureOutput(func() {
+			var err error
+			found, err = s.scan(strings.NewReader(input), "in.txt", tt.multi)
+			if err != nil {
+				t.Errorf("%s: scan error: %v", tt.name, err)
+			}
+		})
+		if out != tt.want || found != tt.found {
+			t.Errorf("%s: scan = %q, %v; want %q, %v", tt.name, out, found, tt.want, tt.found)
+		}
+	}
+
+	none := regex.MustCompile("nothing")
+	for _, tt := range []struct {
+		args Args
+		want string
+	}{
+		{Args{FilesWithoutMatch: true}, "in.txt\n"},
+		{Args{FilesWithMatches: true}, ""},
+		{Args{Count: true}, "0\n"},
+	} {
+		out := captureOutput(func() {
+			if found, _ := newSearcher(none, tt.args).scan(strings.NewReader(input), "in.txt", false); found {
+				t.Errorf("%+v: unexpected match", tt.args)
+			}
+		})
+		if out != tt.want {
+			t.Errorf("%+v: scan = %q, want %q", tt.args, out, tt.want)
+		}
+	}
+}
+
+func TestSearcherPrefixes(t *testing.T) {
+	// The CRLF line checks that offsets count the bytes the scanner strips.
+	input := "a foo\r\nbar\nxx foo foo\n"
+	tests := []struct {
+		name  string
+		args  Args
+		multi bool
+		want  string
+	}{
+		{"line numbers", Args{LineNumber: true}, false, "1:a foo\n3:xx foo foo\n"},
+		{"byte offsets", Args{ByteOffset: true}, false, "0:a foo\n11:xx foo foo\n"},
+		{"column", Args{Column: true}, false, "3:a foo\n4:xx foo foo\n"},
+		{"all with name", Args{LineNumber: true, Column: true, ByteOffset: true}, true,
+			"in.txt:1:3:0:a foo\nin.txt:3:4:11:xx foo foo\n"},
+		{"inverted column", Args{Invert: true, LineNumber: true, Column: true}, false, "2:bar\n"},
+	}
+	re := regex.MustCompile("foo")
+	for _, tt := range tests {
+		s := newSearcher(re, tt.args)
+		out := captureOutput(func() {
+			s.scan(strings.NewReader(input), "in.txt", tt.multi)
+		})
+		if out != tt.want {
+			t.Errorf("%s: scan = %q, want %q", tt.name, out, tt.want)
+		}
+	}
+
+	out := captureOutput(func() {
+		s := newSearcher(re, Args{LineNumber: true})
+		s.scan(strings.NewReader(input), "", true)
+	})
+	if want := "(standard input):1:a foo\n(standard input):3:xx foo foo\n"; out != want {
+		t.Errorf("stdin with name: scan = %q, want %q", out, want)
+	}
+}
+
+func TestSearcherContext(t *testing.T) {
+	input := "1\n2 foo\n3\n4\n5\n6 foo\n7\n8\n9\n10 foo\n"
+	tests := []struct {
+		name  string
+		args  Args
+		multi bool
+		want  string
+	}{
+		{"after", Args{After: 1, Before: -1, Context: -1}, false,
+			"2 foo\n3\n--\n6 foo\n7\n--\n10 foo\n"},
+		{"before", Args{After: -1, Before: 2, Context: -1}, false,
+			"1\n2 foo\n--\n4\n5\n6 foo\n--\n8\n9\n10 foo\n"},
+		{"adjacent groups merge", Args{After: -1, Before: -1, Context: 2}, false,
+			"1\n2 foo\n3\n4\n5\n6 foo\n7\n8\n9\n10 foo\n"},
+		{"-A overrides -C", Args{After: 0, Before: -1, Context: 1}, false,
+			"1\n2 foo\n--\n5\n6 foo\n--\n9\n10 foo\n"},
+		{"prefixes", Args{After: 1, Before: -1, Context: -1, LineNumber: true, MaxCount: 1}, true,
+			"in.txt:2:2 foo\nin.txt-3-3\n"},
+		{"count ignores context", Args{After: 1, Before: -1, Context: -1, Count: true}, false, "3\n"},
+	}
+	re := regex.MustCompile("foo")
+	for _, tt := range tests {
+		s := newSearcher(re, tt.args)
+		out := captureOutput(func() {
+			s.scan(strings.NewReader(input), "in.txt", tt.multi)
+		})
+		if out != tt.want {
+			t.Errorf("%s: scan = %q, want %q", tt.name, out, tt.want)
+		}
+	}
+
+	// Groups in different inputs are separated too.
+	s := newSearcher(re, Args{After: 0, Before: 1, Context: -1})
+	out := captureOutput(func() {
+		s.scan(strings.NewReader("a\nfoo\n"), "a.txt", true)
+		s.scan(strings.NewReader("foo\n"), "b.txt", true)
+	})
+	if want := "a.txt-a\na.txt:foo\n--\nb.txt:foo\n"; out != want {
+		t.Errorf("two inputs: scan = %q, want %q", out, want)
+	}
+}
+
+func TestLineRing(t *testing.T) {
+	r := newLineRing(2)
+	for i := 1; i <= 5; i++ {
+		r.push(i, 0, []byte(strconv.Itoa(i)))
+	}
+	var got []string
+	r.drain(func(l ringLine) { got = append(got, string(l.text)) })
+	if !reflect.DeepEqual(got, []string{"4", "5"}) {
+		t.Errorf("drain = %q, want [4 5]", got)
+	}
+	r.drain(func(l ringLine) { t.Errorf("drain after drain returned %q", l.text) })
+}
+
+func TestSearcherOnlyMatching(t *testing.T) {
+	input := "id=12 id=345\nnone\nx id=6\n"
+	tests := []struct {
+		name    string
+		pattern string
+		args    Args
+		want    string
+	}{
+		{"each match", `id=\d+`, Args{}, "id=12\nid=345\nid=6\n"},
+		{"positions", `id=\d+`, Args{LineNumber: true, Column: true, ByteOffset: true},
+			"1:1:0:id=12\n1:7:6:id=345\n3:3:20:id=6\n"},
+		{"empty matches skipped", `\d*`, Args{}, "12\n345\n6\n"},
+		{"replace", `id=(\d+)`, Args{Replace: ptr("#$1")}, "#12\n#345\n#6\n"},
+		{"inverted prints nothing", `id`, Args{Invert: true}, ""},
+		{"context ignored", `id=6`, Args{Context: 2, After: -1, Before: -1}, "id=6\n"},
+	}
+	for _, tt := range tests {
+		tt.args.OnlyMatching = true
+		s := newSearcher(regex.MustCompile(tt.pattern), tt.args)
+		out := captureOutput(func() {
+			s.scan(strings.NewReader(input), "in.txt", false)
+		})
+		if out != tt.want {
+			t.Errorf("%s: scan = %q, want %q", tt.name, out, tt.want)
+		}
+	}
+}
+
+func ptr(s string) *string { return &s }
+
+func TestParseGrepColors(t *testing.T) {
+	tests := []struct {
+		env  string
+		want palette
+	}{
+		{"", defaultPalette},
+		{"ms=01;32:fn=34", palette{ms: "01;32", mc: "01;31", fn: "34", ln: "32", bn: "32", se: "36"}},
+		{"mt=4:se=:ne", palette{ms: "4", mc: "4", fn: "35", ln: "32", bn: "32", ne: true}},
+		{"sl=1:cx=2:xx=3:ln=bad", palette{ms: "01;31", mc: "01;31", sl: "1", cx: "2", fn: "35", ln: "32", bn: "32", se: "36"}},
+	}
+	for _, tt := range tests {
+		if got := parseGrepColors(tt.env); got != tt.want {
+			t.Errorf("parseGrepColors(%q) = %+v, want %+v", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestSearcherColor(t *testing.T) {
+	const (
+		red  = "\x1b[01;31m\x1b[K"
+		end  = "\x1b[m\x1b[K"
+		sepC = "\x1b[36m\x1b[K"
+	)
+	input := "a foo foo\nbar\n"
+	tests := []struct {
+		name string
+		args Args
+		want string
+	}{
+		{"matches", Args{}, "a " + red + "foo" + end + " " + red + "foo" + end + "\n"},
+		{"prefixes", Args{LineNumber: true}, "\x1b[32m\x1b[K1" + end + sepC + ":" + end +
+			"a " + red + "foo" + end + " " + red + "foo" + end + "\n"},
+		{"replace", Args{Replace: ptr("x")}, "a " + red + "x" + end + " " + red + "x" + end + "\n"},
+		{"only matching", Args{OnlyMatching: true}, red + "foo" + end + "\n" + red + "foo" + end + "\n"},
+		{"inverted context", Args{Invert: true, Before: 1}, "a " + red + "foo" + end + " " + red + "foo" + end + "\nbar\n"},
+		{"never", Args{Color: "never"}, "a foo foo\n"},
+	}
+	re := regex.MustCompile("foo")
+	for _, tt := range tests {
+		if tt.args.Color == "" {
+			tt.args.Color = "always"
+		}
+		s := newSearcher(re, tt.args)
+		out := captureOutput(func() {
+			s.scan(strings.NewReader(input), "in.txt", false)
+		})
+		if out != tt.want {
+			t.Errorf("%s: scan = %q, want %q", tt.name, out, tt.want)
+		}
+	}
+}
+
+func TestSearcherBacktrackerPositions(t *testing.T) {
+	// Every split of the hex run into words is a path, so exploring them
+	// all for the longest match would take 2^32 steps.
+	const input = "deadbeefcafebabe0123456789abcdef!\n"
+	re, err := compilePatterns([]string{`(\w+\s?)+(?=!)`}, Args{Mode: modeExtended})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		args Args
+		want string
+	}{
+		{"only matching", Args{OnlyMatching: true}, "deadbeefcafebabe0123456789abcdef\n"},
+		{"color", Args{Color: "always"}, "\x1b[01;31m\x1b[Kdeadbeefcafebabe0123456789abcdef\x1b[m\x1b[K!\n"},
+	}
+	for _, tt := range tests {
+		s := newSearcher(re, tt.args)
+		done := make(chan string)
+		go func() {
+			done <- captureOutput(func() {
+				s.scan(strings.NewReader(input), "in.txt", false)
+			})
+		}()
+		select {
+		case out := <-done:
+			if out != tt.want {
+				t.Errorf("%s: scan = %q, want %q", tt.name, out, tt.want)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: scan did not finish", tt.name)
+		}
+	}
+}
+
+// onceReader fails the test if it is read after its first line has been
+// consumed by a search that should have stopped.
+type onceReader struct {
+	t    *testing.T
+	data []string
+}
+
+func (r *onceReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		r.t.Errorf("read past the first selected line")
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[0])
+	r.data = r.data[1:]
+	return n, nil
+}
+
+func TestSearcherStopsEarly(t *testing.T) {
+	re := regex.MustCompile("foo")
+	for _, args := range []Args{{Quiet: true}, {FilesWithMatches: true}, {MaxCount: 1}} {
+		s := newSearcher(re, args)
+		captureOutput(func() {
+			s.scan(&onceReader{t: t, data: []string{"foo\n"}}, "in.txt", false)
+		})
+	}
+}